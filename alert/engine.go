@@ -0,0 +1,217 @@
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sys/hardware"
+	"time"
+)
+
+const (
+	ALERT_TMPL         = "alertTmpl.html" //Template file name, loaded with the hardware templates
+	RESOLVED_RETENTION = 15 * time.Minute //How long a resolved alert stay listed
+)
+
+type State string
+
+const (
+	Pending  State = "pending"  //The condition is true but not for long enough yet
+	Firing   State = "firing"   //The condition has been true for the duration of the rule
+	Resolved State = "resolved" //The condition was firing and is now back to normal
+)
+
+// One instance of a rule (a rule matching several keys, like one per partition, has one alert per key)
+type Alert struct {
+	Rule        string     `json:"rule"`
+	Key         string     `json:"key,omitempty"` //Key of the sample (ex: the partition), empty for single value rules
+	State       State      `json:"state"`
+	Severity    string     `json:"severity,omitempty"`
+	Summary     string     `json:"summary,omitempty"`
+	Description string     `json:"description"`           //What triggered the alert, ex: "cpu.usage = 95.00 (> 90)"
+	Value       float64    `json:"value"`                 //Last value of the sample (0 for process rules)
+	ActiveSince time.Time  `json:"active_since"`          //When the condition became true
+	FiredAt     *time.Time `json:"fired_at,omitempty"`    //When the alert started firing, nil while pending
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"` //When the alert was resolved, nil until then
+	UpdatedAt   time.Time  `json:"updated_at"`            //Last evaluation which saw the alert
+}
+
+/*
+ * Engine evaluate the rules after every collection and keep track of the state of every alert:
+ * inactive -> pending -> firing -> resolved. A pending alert whose condition stop being true is dropped
+ */
+type Engine struct {
+	sync.RWMutex
+	rules  []Rule
+	alerts map[string]*Alert //Key: rule name + sample key
+}
+
+func NewEngine(rules []Rule) *Engine {
+	return &Engine{
+		rules:  rules,
+		alerts: make(map[string]*Alert),
+	}
+}
+
+func (engine *Engine) Rules() []Rule {
+	return append([]Rule{}, engine.rules...)
+}
+
+// Result of evaluating a rule for one key
+type observation struct {
+	key         string
+	value       float64
+	description string
+	breached    bool //Whether the condition is true
+}
+
+/*
+ * Return an observation for every key the rule has data for this evaluation, none if its data is unknown
+ * (the collector was skipped by its interval, failed or timed out)
+ */
+func (engine *Engine) observe(rule *Rule, samples []hardware.Sample, processes hardware.Processes) []observation {
+	var observations []observation
+
+	if rule.Process != "" {
+		if processes == nil {
+			return nil
+		}
+		for _, proc := range processes {
+			if proc.Name == rule.Process {
+				return []observation{{description: fmt.Sprintf("process %s is running", rule.Process)}}
+			}
+		}
+		return []observation{{description: fmt.Sprintf("process %s is not running", rule.Process), breached: true}}
+	}
+
+	for _, sample := range samples {
+		key, ok := rule.match(sample.Name)
+		if !ok {
+			continue
+		}
+
+		//A sample of an alert already pending or firing is compared with the hysteresis
+		existing, active := engine.alerts[rule.Name+"|"+key]
+		active = active && existing.State != Resolved
+		observations = append(observations, observation{
+			key:         key,
+			value:       sample.Value,
+			description: fmt.Sprintf("%s = %.2f (%s %g)", sample.Name, sample.Value, rule.Op, rule.Threshold),
+			breached:    rule.breached(sample.Value, active),
+		})
+	}
+	return observations
+}
+
+/*
+ * Evaluate every rule against the samples and processes of the last collection.
+ * The samples only come from the collectors which succeeded this collection, and processes is nil when they are
+ * unknown. A rule without any data (collector skipped by its interval, failing or timed out) is skipped and its alerts
+ * keep their state: missing data is not a recovery. An alert is only resolved by a sample back within the hysteresis,
+ * or when its key is gone (ex: an unmounted partition) while the rule still has data for other keys.
+ * Return a copy of the alerts which started firing or were resolved during this evaluation
+ */
+func (engine *Engine) Evaluate(now time.Time, samples []hardware.Sample, processes hardware.Processes) []Alert {
+	engine.Lock()
+	defer engine.Unlock()
+
+	var changed []Alert
+	seen := make(map[string]bool)
+
+	for i := range engine.rules {
+		rule := &engine.rules[i]
+		observations := engine.observe(rule, samples, processes)
+		if len(observations) == 0 {
+			for id, alert := range engine.alerts {
+				if alert.Rule == rule.Name && alert.State != Resolved {
+					seen[id] = true
+				}
+			}
+			continue
+		}
+
+		for _, obs := range observations {
+			if !obs.breached {
+				continue
+			}
+			id := rule.Name + "|" + obs.key
+			seen[id] = true
+
+			alert, ok := engine.alerts[id]
+			if !ok || alert.State == Resolved {
+				//The condition just became true
+				alert = &Alert{
+					Rule:        rule.Name,
+					Key:         obs.key,
+					State:       Pending,
+					Severity:    rule.Severity,
+					Summary:     rule.Summary,
+					ActiveSince: now,
+				}
+				engine.alerts[id] = alert
+			}
+
+			alert.Value = obs.value
+			alert.Description = obs.description
+			alert.UpdatedAt = now
+
+			if alert.State == Pending && now.Sub(alert.ActiveSince) >= time.Duration(rule.For) {
+				alert.State = Firing
+				alert.FiredAt = &now
+				changed = append(changed, *alert)
+			}
+		}
+	}
+
+	//Alerts whose condition is not true anymore
+	for id, alert := range engine.alerts {
+		if seen[id] {
+			continue
+		}
+
+		switch alert.State {
+		case Pending:
+			delete(engine.alerts, id)
+		case Firing:
+			alert.State = Resolved
+			alert.ResolvedAt = &now
+			alert.UpdatedAt = now
+			changed = append(changed, *alert)
+		case Resolved:
+			if now.Sub(*alert.ResolvedAt) > RESOLVED_RETENTION {
+				delete(engine.alerts, id)
+			}
+		}
+	}
+
+	return changed
+}
+
+// Return a copy of every alert, firing first then pending then resolved
+func (engine *Engine) Alerts() []Alert {
+	engine.RLock()
+	defer engine.RUnlock()
+
+	alerts := make([]Alert, 0, len(engine.alerts))
+	for _, alert := range engine.alerts {
+		alerts = append(alerts, *alert)
+	}
+
+	order := map[State]int{Firing: 0, Pending: 1, Resolved: 2}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].State != alerts[j].State {
+			return order[alerts[i].State] < order[alerts[j].State]
+		}
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Key < alerts[j].Key
+	})
+	return alerts
+}
+
+// Return the HTML representation of the alerts
+func (engine *Engine) ToHtml() (string, error) {
+	html, err := hardware.ExecuteTemplate(ALERT_TMPL, engine.Alerts())
+	return string(html), err
+}
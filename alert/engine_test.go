@@ -0,0 +1,157 @@
+package alert
+
+import (
+	"sys/hardware"
+	"testing"
+	"time"
+)
+
+var start = time.Unix(1700000000, 0)
+
+func cpuSample(value float64) []hardware.Sample {
+	return []hardware.Sample{{Name: "cpu.usage", Value: value}}
+}
+
+// State of the alert of a rule for a key, empty if there is none
+func stateOf(engine *Engine, rule, key string) State {
+	for _, alert := range engine.Alerts() {
+		if alert.Rule == rule && alert.Key == key {
+			return alert.State
+		}
+	}
+	return ""
+}
+
+func TestEvaluateLifecycle(t *testing.T) {
+	engine := NewEngine([]Rule{{Name: "high_cpu", Metric: "cpu.usage", Op: ">", Threshold: 90, For: Duration(2 * time.Minute)}})
+
+	steps := []struct {
+		offset  time.Duration
+		value   float64
+		state   State
+		changed int //Number of alerts returned by Evaluate
+	}{
+		{0, 95, Pending, 0},
+		{time.Minute, 99, Pending, 0},
+		{2 * time.Minute, 96, Firing, 1},
+		{3 * time.Minute, 97, Firing, 0},
+		{4 * time.Minute, 50, Resolved, 1},
+		{5 * time.Minute, 40, Resolved, 0},
+	}
+	for _, step := range steps {
+		changed := engine.Evaluate(start.Add(step.offset), cpuSample(step.value), hardware.Processes{})
+		if len(changed) != step.changed {
+			t.Errorf("at +%v: %d changed alerts, want %d", step.offset, len(changed), step.changed)
+		}
+		if state := stateOf(engine, "high_cpu", ""); state != step.state {
+			t.Errorf("at +%v: state = %q, want %q", step.offset, state, step.state)
+		}
+	}
+
+	alert := engine.Alerts()[0]
+	if alert.FiredAt == nil || !alert.FiredAt.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("FiredAt = %v, want +2m", alert.FiredAt)
+	}
+	if alert.ResolvedAt == nil || !alert.ResolvedAt.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("ResolvedAt = %v, want +4m", alert.ResolvedAt)
+	}
+
+	//Resolved alerts are forgotten after the retention
+	engine.Evaluate(start.Add(20*time.Minute), cpuSample(10), hardware.Processes{})
+	if len(engine.Alerts()) != 0 {
+		t.Errorf("alerts after the retention = %+v", engine.Alerts())
+	}
+
+	//A pending alert whose condition stop being true is dropped without notification
+	engine.Evaluate(start.Add(21*time.Minute), cpuSample(95), hardware.Processes{})
+	if changed := engine.Evaluate(start.Add(22*time.Minute), cpuSample(10), hardware.Processes{}); len(changed) != 0 {
+		t.Errorf("dropping a pending alert returned %+v", changed)
+	}
+	if len(engine.Alerts()) != 0 {
+		t.Errorf("alerts after dropping the pending alert = %+v", engine.Alerts())
+	}
+}
+
+func TestEvaluateHysteresis(t *testing.T) {
+	engine := NewEngine([]Rule{{Name: "high_cpu", Metric: "cpu.usage", Op: ">", Threshold: 90, Hysteresis: 5}})
+
+	steps := []struct {
+		value float64
+		state State
+	}{
+		{88, ""},     //Below the threshold, the hysteresis only applies to an active alert
+		{91, Firing}, //No for: fires right away
+		{88, Firing}, //Back below the threshold but not below threshold - hysteresis
+		{85.5, Firing},
+		{85, Resolved}, //> 85 is not true anymore
+		{88, Resolved}, //Inactive again: the plain threshold applies
+		{90.5, Firing},
+	}
+	for i, step := range steps {
+		engine.Evaluate(start.Add(time.Duration(i)*time.Second), cpuSample(step.value), hardware.Processes{})
+		if state := stateOf(engine, "high_cpu", ""); state != step.state {
+			t.Errorf("step %d (value %g): state = %q, want %q", i, step.value, state, step.state)
+		}
+	}
+}
+
+func TestEvaluateMissingData(t *testing.T) {
+	engine := NewEngine([]Rule{
+		{Name: "high_cpu", Metric: "cpu.usage", Op: ">", Threshold: 90, For: Duration(2 * time.Minute)},
+		{Name: "disk_full", Metric: "disk.free_percent:*", Op: "<", Threshold: 5},
+		{Name: "nginx_down", Process: "nginx"},
+	})
+	disks := func(values map[string]float64) []hardware.Sample {
+		var samples []hardware.Sample
+		for key, value := range values {
+			samples = append(samples, hardware.Sample{Name: "disk.free_percent:" + key, Value: value})
+		}
+		return samples
+	}
+
+	//A pending alert survives the collections where its collector has no data, and fires once the duration is over
+	engine.Evaluate(start, cpuSample(95), hardware.Processes{})
+	for offset := 30 * time.Second; offset < 2*time.Minute; offset += 30 * time.Second {
+		if changed := engine.Evaluate(start.Add(offset), nil, nil); len(changed) != 0 {
+			t.Errorf("at +%v without data: changed = %+v", offset, changed)
+		}
+		if state := stateOf(engine, "high_cpu", ""); state != Pending {
+			t.Fatalf("at +%v without data: state = %q, want pending", offset, state)
+		}
+	}
+	if changed := engine.Evaluate(start.Add(2*time.Minute), cpuSample(95), hardware.Processes{}); len(changed) != 1 {
+		t.Errorf("pending alert did not fire after its duration: changed = %+v", changed)
+	}
+
+	//Firing alerts are not resolved by a collection without their data
+	engine.Evaluate(start.Add(3*time.Minute), disks(map[string]float64{"/": 1, "/home": 2}), hardware.Processes{})
+	if changed := engine.Evaluate(start.Add(4*time.Minute), nil, nil); len(changed) != 0 {
+		t.Errorf("collection without data changed %+v", changed)
+	}
+	for _, key := range []string{"/", "/home"} {
+		if state := stateOf(engine, "disk_full", key); state != Firing {
+			t.Errorf("disk_full %s after a collection without data = %q, want firing", key, state)
+		}
+	}
+	if state := stateOf(engine, "nginx_down", ""); state != Firing {
+		t.Errorf("nginx_down after a collection without processes = %q, want firing", state)
+	}
+	if state := stateOf(engine, "high_cpu", ""); state != Firing {
+		t.Errorf("high_cpu after a collection without data = %q, want firing", state)
+	}
+
+	//With data for the rule, a key which is gone is resolved, the others are evaluated
+	changed := engine.Evaluate(start.Add(5*time.Minute), disks(map[string]float64{"/": 1}), hardware.Processes{{Name: "nginx"}})
+	if len(changed) != 2 {
+		t.Errorf("changed = %+v, want /home and nginx_down resolved", changed)
+	}
+	if state := stateOf(engine, "disk_full", "/home"); state != Resolved {
+		t.Errorf("disk_full /home once gone = %q, want resolved", state)
+	}
+	if state := stateOf(engine, "disk_full", "/"); state != Firing {
+		t.Errorf("disk_full / = %q, want firing", state)
+	}
+	if state := stateOf(engine, "nginx_down", ""); state != Resolved {
+		t.Errorf("nginx_down once running = %q, want resolved", state)
+	}
+}
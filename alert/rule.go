@@ -0,0 +1,145 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sys/hardware"
+	"time"
+)
+
+// Duration which can be written as "2m" or "30s" in the rules file
+type Duration time.Duration
+
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("duration must be a string like \"2m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*duration = Duration(parsed)
+	return nil
+}
+
+func (duration Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(duration).String())
+}
+
+/*
+ * A Rule describe a condition to watch. There are two kinds of rules:
+ * 1. Threshold rules compare a sample (see hardware.Sample) with a threshold:
+ *	{"name": "high_cpu", "metric": "cpu.usage", "op": ">", "threshold": 90, "for": "2m"}
+ *    A metric ending with ":*" match every key, one alert is raised per key:
+ *	{"name": "disk_full", "metric": "disk.free_percent:*", "op": "<", "threshold": 5}
+ * 2. Process rules fire when no process with the given name is running:
+ *	{"name": "nginx_down", "process": "nginx", "for": "30s"}
+ */
+type Rule struct {
+	Name       string   `json:"name"`
+	Metric     string   `json:"metric,omitempty"`     //Sample name to compare
+	Op         string   `json:"op,omitempty"`         //Comparison operator: >, >=, < or <=
+	Threshold  float64  `json:"threshold,omitempty"`  //Value compared with the sample
+	Process    string   `json:"process,omitempty"`    //Name of a process which must be running
+	For        Duration `json:"for,omitempty"`        //How long the condition must hold before firing
+	Hysteresis float64  `json:"hysteresis,omitempty"` //How far back past the threshold the value must go to resolve
+	Severity   string   `json:"severity,omitempty"`   //Free text, ex: warning, critical
+	Summary    string   `json:"summary,omitempty"`    //Human readable description of the problem
+}
+
+func (rule *Rule) Validate() error {
+	if rule.Name == "" {
+		return errors.New("rule without name")
+	}
+	if (rule.Metric == "") == (rule.Process == "") {
+		return fmt.Errorf("rule %s: exactly one of metric or process must be set", rule.Name)
+	}
+	if rule.Metric != "" {
+		switch rule.Op {
+		case ">", ">=", "<", "<=":
+		default:
+			return fmt.Errorf("rule %s: invalid operator %q", rule.Name, rule.Op)
+		}
+	}
+	if rule.For < 0 || rule.Hysteresis < 0 {
+		return fmt.Errorf("rule %s: for and hysteresis cannot be negative", rule.Name)
+	}
+	return nil
+}
+
+// Return the key of the sample if it match the rule metric
+func (rule *Rule) match(sample string) (string, bool) {
+	if prefix, ok := strings.CutSuffix(rule.Metric, ":*"); ok {
+		return strings.CutPrefix(sample, prefix+":")
+	}
+	return "", sample == rule.Metric
+}
+
+/*
+ * Compare the value with the threshold. While the alert is active (pending or firing), the threshold is
+ * moved back by the hysteresis so a value hovering around the threshold does not flap between states
+ */
+func (rule *Rule) breached(value float64, active bool) bool {
+	threshold := rule.Threshold
+	if active {
+		if rule.Op == ">" || rule.Op == ">=" {
+			threshold -= rule.Hysteresis
+		} else {
+			threshold += rule.Hysteresis
+		}
+	}
+
+	switch rule.Op {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	default:
+		return value <= threshold
+	}
+}
+
+// Check that the data needed by the rules is collected: the process rules need the processes collector
+func CheckCollectors(rules []Rule, collectors []string) error {
+	for _, rule := range rules {
+		if rule.Process != "" && !slices.Contains(collectors, hardware.PROCESS_COLLECTOR) {
+			return fmt.Errorf("rule %s watches a process but the %s collector is not enabled", rule.Name, hardware.PROCESS_COLLECTOR)
+		}
+	}
+	return nil
+}
+
+// Read the rules from a JSON file containing an array of rules. A missing file mean no rule
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range rules {
+		if err = rules[i].Validate(); err != nil {
+			return nil, err
+		}
+		if names[rules[i].Name] {
+			return nil, fmt.Errorf("rule %s is defined twice", rules[i].Name)
+		}
+		names[rules[i].Name] = true
+	}
+	return rules, nil
+}
@@ -0,0 +1,176 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outcome of an action
+const (
+	SUCCESS = "success"
+	FAILURE = "failure"
+)
+
+// One action performed on a process, as it was requested and what happened
+type Entry struct {
+	Time        time.Time `json:"time"`
+	User        string    `json:"user"`
+	Role        string    `json:"role"`
+	AuthMethod  string    `json:"auth_method"` //session, token or none (authentication disabled)
+	RemoteAddr  string    `json:"remote_addr"`
+	Action      string    `json:"action"`
+	PID         int32     `json:"pid"`
+	ProcessName string    `json:"process_name,omitempty"` //Name of the process before the action
+	Cmdline     string    `json:"cmdline,omitempty"`      //Command line of the process before the action
+	Signal      int       `json:"signal,omitempty"`       //Signal number, for send_signal
+	Descendants []int32   `json:"descendants,omitempty"`  //Descendants killed with the process, for kill_tree
+	Outcome     string    `json:"outcome"`                //success or failure
+	Error       string    `json:"error,omitempty"`        //Why the action failed
+}
+
+// Criteria of a query, zero values match everything
+type Filter struct {
+	From   time.Time
+	To     time.Time
+	User   string
+	Action string
+	PID    int32
+	Limit  int //Only the last Limit matching entries are returned
+}
+
+func (filter Filter) match(entry Entry) bool {
+	return (filter.From.IsZero() || !entry.Time.Before(filter.From)) &&
+		(filter.To.IsZero() || !entry.Time.After(filter.To)) &&
+		(filter.User == "" || entry.User == filter.User) &&
+		(filter.Action == "" || entry.Action == filter.Action) &&
+		(filter.PID == 0 || entry.PID == filter.PID)
+}
+
+/*
+ * Append-only audit log, one JSON entry per line. Entries are never rewritten:
+ * the file is opened in append mode and synced after every entry so a crash cannot lose a recorded action
+ */
+type Log struct {
+	sync.Mutex
+	path string
+	file *os.File
+}
+
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	//A line torn by a crash is ended, so the next entry starts on its own line instead of being glued to it
+	if torn, err := tornTail(path); err != nil || torn {
+		if err == nil {
+			_, err = file.Write([]byte{'\n'})
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &Log{path: path, file: file}, nil
+}
+
+// Whether the file does not end with a new line
+func tornTail(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return false, err
+	}
+	last := make([]byte, 1)
+	if _, err = file.ReadAt(last, info.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] != '\n', nil
+}
+
+func (log *Log) Record(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	log.Lock()
+	defer log.Unlock()
+
+	if log.file == nil {
+		return errors.New("audit log is closed")
+	}
+	if _, err = log.file.Write(data); err != nil {
+		return err
+	}
+	return log.file.Sync()
+}
+
+// Return the matching entries, oldest first
+func (log *Log) Query(filter Filter) ([]Entry, error) {
+	//Hold the lock so we never read a half-written line
+	log.Lock()
+	defer log.Unlock()
+
+	file, err := os.Open(log.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) //Command lines can be long
+	line, skipped, firstSkipped := 0, 0, 0
+	for scanner.Scan() {
+		line++
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			//A line torn by a crash or damaged on disk must not hide the rest of the trail
+			if skipped == 0 {
+				firstSkipped = line
+			}
+			skipped++
+			continue
+		}
+		if !filter.match(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+		if filter.Limit > 0 && len(entries) > filter.Limit {
+			entries = entries[1:]
+		}
+	}
+	if skipped > 0 {
+		slog.Warn("Skipped unreadable audit entries", "path", log.path, "lines", skipped, "first_line", firstSkipped)
+	}
+	return entries, scanner.Err()
+}
+
+func (log *Log) Close() error {
+	log.Lock()
+	defer log.Unlock()
+
+	if log.file == nil {
+		return nil
+	}
+	err := log.file.Close()
+	log.file = nil
+	return err
+}
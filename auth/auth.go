@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SESSION_COOKIE = "sysmon_session"
+	LOGIN_PATH     = "/login"
+	LOGOUT_PATH    = "/logout"
+)
+
+// How a request has been authenticated
+const (
+	METHOD_NONE    = "none"    //Authentication disabled
+	METHOD_SESSION = "session" //Session cookie of the web UI
+	METHOD_TOKEN   = "token"   //API token
+)
+
+// User of the requests when the authentication is disabled
+var Anonymous = &User{Name: "anonymous", Role: Operator}
+
+type contextKey struct{}
+
+type identity struct {
+	user   *User
+	method string
+}
+
+// Return the user attached to the request by Require, nil if there is none
+func UserFromContext(ctx context.Context) *User {
+	id, _ := ctx.Value(contextKey{}).(identity)
+	return id.user
+}
+
+// Return how the user attached to the request has been authenticated (METHOD_*), empty if there is none
+func MethodFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(identity)
+	return id.method
+}
+
+/*
+ * Authenticate the requests with an API token (Authorization: Bearer <token>) or a session cookie.
+ * A nil authenticator means the authentication is disabled: every request is made by Anonymous
+ */
+type Authenticator struct {
+	users    *Users
+	sessions *Sessions
+}
+
+func NewAuthenticator(users *Users, sessionTTL time.Duration) *Authenticator {
+	return &Authenticator{
+		users:    users,
+		sessions: NewSessions(sessionTTL),
+	}
+}
+
+// Find the user making the request and how it is authenticated, nil if it is not authenticated
+func (authenticator *Authenticator) User(r *http.Request) (*User, string) {
+	if authenticator == nil {
+		return Anonymous, METHOD_NONE
+	}
+
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, isBearer := strings.CutPrefix(header, "Bearer ")
+		if !isBearer {
+			return nil, ""
+		}
+		return authenticator.users.Token(strings.TrimSpace(token)), METHOD_TOKEN
+	}
+
+	cookie, err := r.Cookie(SESSION_COOKIE)
+	if err != nil {
+		return nil, ""
+	}
+	return authenticator.sessions.Get(cookie.Value), METHOD_SESSION
+}
+
+// Wrap a handler so it is only reachable by users having at least the required role
+func (authenticator *Authenticator) Require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, method := authenticator.User(r)
+		if user == nil {
+			//Send the browsers to the login page, the other clients get a plain 401
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, LOGIN_PATH, http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sysmon"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if !user.Role.Allows(role) {
+			http.Error(w, "Permission denied: "+string(role)+" role required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, identity{user, method})))
+	})
+}
+
+// Handle the login form (username and password fields), open a session and go back to the dashboard
+func (authenticator *Authenticator) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if authenticator == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	name := r.PostFormValue("username")
+	user := authenticator.users.Authenticate(name, r.PostFormValue("password"))
+	if user == nil {
+		slog.Warn("Login failed", "user", name, "remote_addr", r.RemoteAddr)
+		http.Redirect(w, r, LOGIN_PATH+"?error=1", http.StatusSeeOther)
+		return
+	}
+
+	id, expires, err := authenticator.sessions.Create(user)
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("User logged in", "user", user.Name, "role", user.Role, "remote_addr", r.RemoteAddr)
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode, //The browser does not send it with cross-site requests (CSRF)
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Close the session of the request and go back to the login page
+func (authenticator *Authenticator) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if authenticator != nil {
+		if cookie, err := r.Cookie(SESSION_COOKIE); err == nil {
+			authenticator.sessions.Delete(cookie.Value)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, LOGIN_PATH, http.StatusSeeOther)
+}
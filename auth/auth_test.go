@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write a users file with a viewer and an operator, each owning one API token, and return their tokens
+func loadTestUsers(t *testing.T) (users *Users, viewerToken, operatorToken string) {
+	t.Helper()
+	viewerToken, viewerHash, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	operatorToken, operatorHash, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "users.json")
+	data := `{"users": [
+		{"name": "viewer", "role": "viewer", "tokens": ["` + viewerHash + `"]},
+		{"name": "operator", "role": "operator", "tokens": ["` + operatorHash + `"]}
+	]}`
+	if err = os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if users, err = LoadUsers(path); err != nil {
+		t.Fatalf("LoadUsers: %v", err)
+	}
+	return users, viewerToken, operatorToken
+}
+
+func TestUsersToken(t *testing.T) {
+	users, viewerToken, operatorToken := loadTestUsers(t)
+
+	if user := users.Token(viewerToken); user == nil || user.Name != "viewer" {
+		t.Errorf("Token(viewer token) = %+v, want viewer", user)
+	}
+	if user := users.Token(operatorToken); user == nil || user.Name != "operator" {
+		t.Errorf("Token(operator token) = %+v, want operator", user)
+	}
+	if user := users.Token("unknown"); user != nil {
+		t.Errorf("Token(unknown) = %+v, want nil", user)
+	}
+	//Only the hashes are known, the hash itself is not a token
+	if user := users.Token(HashToken(viewerToken)); user != nil {
+		t.Errorf("Token(hash) = %+v, want nil", user)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	users, viewerToken, operatorToken := loadTestUsers(t)
+	authenticator := NewAuthenticator(users, time.Hour)
+
+	var reached *User
+	handler := authenticator.Require(Operator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = UserFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+		wantUser      string
+	}{
+		{"anonymous", "", http.StatusUnauthorized, ""},
+		{"unknown token", "Bearer unknown", http.StatusUnauthorized, ""},
+		{"viewer", "Bearer " + viewerToken, http.StatusForbidden, ""},
+		{"operator", "Bearer " + operatorToken, http.StatusOK, "operator"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reached = nil
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/processes/1/kill", nil)
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.want {
+				t.Errorf("status = %d, want %d", w.Code, test.want)
+			}
+			if test.wantUser == "" && reached != nil {
+				t.Errorf("handler reached by %s", reached.Name)
+			}
+			if test.wantUser != "" && (reached == nil || reached.Name != test.wantUser) {
+				t.Errorf("handler reached by %+v, want %s", reached, test.wantUser)
+			}
+		})
+	}
+}
+
+func TestExpiredSession(t *testing.T) {
+	users, _, _ := loadTestUsers(t)
+	authenticator := NewAuthenticator(users, time.Hour)
+	viewer := users.byName["viewer"]
+
+	id, _, err := authenticator.sessions.Create(viewer)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/system", nil)
+		r.AddCookie(&http.Cookie{Name: SESSION_COOKIE, Value: id})
+		return r
+	}
+
+	if user, method := authenticator.User(request()); user != viewer || method != METHOD_SESSION {
+		t.Fatalf("User = %+v, %s, want the viewer session", user, method)
+	}
+
+	//Move the expiry in the past
+	authenticator.sessions.Lock()
+	expired := authenticator.sessions.sessions[id]
+	expired.expires = time.Now().Add(-time.Second)
+	authenticator.sessions.sessions[id] = expired
+	authenticator.sessions.Unlock()
+
+	if user, _ := authenticator.User(request()); user != nil {
+		t.Errorf("User of an expired session = %+v, want nil", user)
+	}
+	if _, exists := authenticator.sessions.sessions[id]; exists {
+		t.Error("expired session is not deleted")
+	}
+	w := httptest.NewRecorder()
+	authenticator.Require(Viewer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached with an expired session")
+	})).ServeHTTP(w, request())
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
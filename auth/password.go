@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	PASSWORD_SCHEME     = "pbkdf2-sha256"
+	PASSWORD_ITERATIONS = 600000 //OWASP recommendation for PBKDF2-HMAC-SHA256
+	PASSWORD_SALT_SIZE  = 16
+	TOKEN_SCHEME        = "sha256"
+	TOKEN_SIZE          = 32
+)
+
+/*
+ * Hash a password with PBKDF2-HMAC-SHA256, the result is stored in the users file as:
+ * pbkdf2-sha256$<iterations>$<base64 salt>$<base64 key>
+ */
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, PASSWORD_SALT_SIZE)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := pbkdf2([]byte(password), salt, PASSWORD_ITERATIONS, sha256.Size)
+	encoding := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", PASSWORD_SCHEME, PASSWORD_ITERATIONS, encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+}
+
+// Check a password against a hash produced by HashPassword
+func CheckPassword(hash, password string) bool {
+	iterations, salt, key, err := parsePasswordHash(hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(pbkdf2([]byte(password), salt, iterations, len(key)), key) == 1
+}
+
+func parsePasswordHash(hash string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != PASSWORD_SCHEME {
+		return 0, nil, nil, fmt.Errorf("unsupported password hash, expected %s$<iterations>$<salt>$<key>", PASSWORD_SCHEME)
+	}
+
+	iterations, err = strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, errors.New("invalid password hash iterations")
+	}
+	encoding := base64.RawStdEncoding
+	if salt, err = encoding.DecodeString(parts[2]); err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid password hash salt: %w", err)
+	}
+	if key, err = encoding.DecodeString(parts[3]); err != nil || len(key) == 0 {
+		return 0, nil, nil, errors.New("invalid password hash key")
+	}
+	return iterations, salt, key, nil
+}
+
+// PBKDF2 (RFC 8018) with HMAC-SHA256
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	mac := hmac.New(sha256.New, password)
+	key := make([]byte, 0, keyLen)
+	block := make([]byte, 4)
+	var u []byte
+	for index := uint32(1); len(key) < keyLen; index++ {
+		//U1 = HMAC(password, salt || INT(index))
+		block[0], block[1], block[2], block[3] = byte(index>>24), byte(index>>16), byte(index>>8), byte(index)
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write(block)
+		u = mac.Sum(u[:0])
+
+		//T = U1 ^ U2 ^ ... ^ Un, with Ui = HMAC(password, Ui-1)
+		t := make([]byte, len(u))
+		copy(t, u)
+		for range iterations - 1 {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+/*
+ * Generate a random API token. The token is shown once to the user,
+ * only its hash (sha256$<hex>) is stored in the users file
+ */
+func NewToken() (token, hash string, err error) {
+	raw := make([]byte, TOKEN_SIZE)
+	if _, err = rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashToken(token), nil
+}
+
+// API tokens are long random values, a single SHA-256 is enough to avoid storing them in clear
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return TOKEN_SCHEME + "$" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPbkdf2(t *testing.T) {
+	//PBKDF2-HMAC-SHA256 vectors of RFC 7914 section 11 and of the RFC 6070 inputs
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+	}
+	for _, test := range tests {
+		got := hex.EncodeToString(pbkdf2([]byte(test.password), []byte(test.salt), test.iterations, test.keyLen))
+		if got != test.want {
+			t.Errorf("pbkdf2(%q, %q, %d, %d) = %s, want %s", test.password, test.salt, test.iterations, test.keyLen, got, test.want)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if !strings.HasPrefix(hash, PASSWORD_SCHEME+"$") {
+		t.Errorf("hash = %s, want the %s scheme", hash, PASSWORD_SCHEME)
+	}
+
+	if !CheckPassword(hash, "secret") {
+		t.Error("the right password is rejected")
+	}
+	if CheckPassword(hash, "Secret") {
+		t.Error("a wrong password is accepted")
+	}
+	if CheckPassword("sha256$"+strings.Repeat("0", 64), "secret") {
+		t.Error("a hash of another scheme is accepted")
+	}
+
+	//Every hash has its own salt
+	if other, _ := HashPassword("secret"); other == hash {
+		t.Error("two hashes of the same password are equal")
+	}
+}
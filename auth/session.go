@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+const SESSION_ID_SIZE = 32
+
+type session struct {
+	user    *User
+	expires time.Time
+}
+
+// In-memory sessions of the web UI, they are lost when the server restart
+type Sessions struct {
+	sync.Mutex
+	ttl      time.Duration
+	sessions map[string]session //Session ID -> session
+}
+
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{
+		ttl:      ttl,
+		sessions: make(map[string]session),
+	}
+}
+
+// Open a session for the user, return its ID and expiry
+func (sessions *Sessions) Create(user *User) (string, time.Time, error) {
+	raw := make([]byte, SESSION_ID_SIZE)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	//Drop the expired sessions so the map does not grow forever
+	now := time.Now()
+	for key, existing := range sessions.sessions {
+		if now.After(existing.expires) {
+			delete(sessions.sessions, key)
+		}
+	}
+
+	expires := now.Add(sessions.ttl)
+	sessions.sessions[id] = session{user: user, expires: expires}
+	return id, expires, nil
+}
+
+// Return the user of a session, nil if the session is unknown or expired
+func (sessions *Sessions) Get(id string) *User {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	existing, exists := sessions.sessions[id]
+	if !exists {
+		return nil
+	}
+	if time.Now().After(existing.expires) {
+		delete(sessions.sessions, id)
+		return nil
+	}
+	return existing.user
+}
+
+func (sessions *Sessions) Delete(id string) {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	delete(sessions.sessions, id)
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Role of a user, each role include the permissions of the ones before it
+type Role string
+
+const (
+	Viewer   Role = "viewer"   //Can see the dashboard and read the API
+	Operator Role = "operator" //Can also kill, terminate or signal processes
+)
+
+var roleRanks = map[Role]int{
+	Viewer:   1,
+	Operator: 2,
+}
+
+// Whether the role has at least the permissions of the required one
+func (role Role) Allows(required Role) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// Name of the operator created by CreateFirstUser
+const FIRST_USER = "admin"
+
+type User struct {
+	Name     string   `json:"name"`
+	Role     Role     `json:"role"`
+	Password string   `json:"password,omitempty"` //Hash produced by HashPassword, empty for token-only users
+	Tokens   []string `json:"tokens,omitempty"`   //Hashes of the API tokens, produced by NewToken
+}
+
+/*
+ * Users allowed to access the server, read from a JSON file:
+ *
+ *	{
+ *		"users": [
+ *			{"name": "admin", "role": "operator", "password": "pbkdf2-sha256$600000$..."},
+ *			{"name": "prometheus", "role": "viewer", "tokens": ["sha256$..."]}
+ *		]
+ *	}
+ */
+type Users struct {
+	byName  map[string]*User
+	byToken map[string]*User //Token hash -> user
+}
+
+// Hash checked when the user does not exist, so the response time does not reveal it (computed on first use)
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("")
+	return hash
+})
+
+func LoadUsers(path string) (*Users, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Users []*User `json:"users"`
+	}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	users := &Users{
+		byName:  make(map[string]*User),
+		byToken: make(map[string]*User),
+	}
+	for _, user := range file.Users {
+		if err := users.add(user); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(users.byName) == 0 {
+		return nil, fmt.Errorf("%s: no user defined", path)
+	}
+	return users, nil
+}
+
+/*
+ * Create the users file with a single operator "admin", for a first start without it.
+ * Return the generated password and API token, only their hashes are written.
+ * Fail if the file already exists
+ */
+func CreateFirstUser(path string) (password, token string, err error) {
+	password, _, err = NewToken() //A random token is as good a password as any
+	if err != nil {
+		return "", "", err
+	}
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return "", "", err
+	}
+	token, tokenHash, err := NewToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Users []*User `json:"users"`
+	}{[]*User{{Name: FIRST_USER, Role: Operator, Password: passwordHash, Tokens: []string{tokenHash}}}}, "", "  ")
+	if err != nil {
+		return "", "", err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", "", err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(path)
+		return "", "", err
+	}
+	return password, token, nil
+}
+
+func (users *Users) add(user *User) error {
+	if user.Name == "" {
+		return errors.New("user without name")
+	}
+	if _, exists := users.byName[user.Name]; exists {
+		return fmt.Errorf("user %s is defined twice", user.Name)
+	}
+	if _, known := roleRanks[user.Role]; !known {
+		return fmt.Errorf("user %s: invalid role %q (viewer or operator)", user.Name, user.Role)
+	}
+	if user.Password == "" && len(user.Tokens) == 0 {
+		return fmt.Errorf("user %s: a password or a token is required", user.Name)
+	}
+	if user.Password != "" {
+		if _, _, _, err := parsePasswordHash(user.Password); err != nil {
+			return fmt.Errorf("user %s: %w", user.Name, err)
+		}
+	}
+	for _, token := range user.Tokens {
+		if !strings.HasPrefix(token, TOKEN_SCHEME+"$") {
+			return fmt.Errorf("user %s: unsupported token hash, expected %s$<hex>", user.Name, TOKEN_SCHEME)
+		}
+		if _, exists := users.byToken[token]; exists {
+			return fmt.Errorf("user %s: token is already used by another user", user.Name)
+		}
+		users.byToken[token] = user
+	}
+
+	users.byName[user.Name] = user
+	return nil
+}
+
+// Check the credentials of a user, return nil if they are wrong
+func (users *Users) Authenticate(name, password string) *User {
+	user, exists := users.byName[name]
+	if !exists || user.Password == "" {
+		CheckPassword(dummyHash(), password)
+		return nil
+	}
+	if !CheckPassword(user.Password, password) {
+		return nil
+	}
+	return user
+}
+
+// Find the owner of an API token, return nil if the token is unknown
+func (users *Users) Token(token string) *User {
+	return users.byToken[HashToken(token)]
+}
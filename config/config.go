@@ -0,0 +1,387 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sys/hardware"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * Configuration of the server. Values are taken, from lowest to highest priority, from:
+ * 1. The defaults below
+ * 2. The YAML configuration file (-config flag or SYSMON_CONFIG, ./sysmon.yaml if it exists)
+ * 3. The SYSMON_* environment variables
+ * 4. The command-line flags
+ */
+type Config struct {
+	Listen          string           `yaml:"listen"`           //Address the HTTP server listen on
+	Interval        time.Duration    `yaml:"interval"`         //Interval of the collection loop
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout"` //Deadline of the graceful shutdown
+	Dev             bool             `yaml:"dev"`              //Development mode: reload the templates when they change
+	Collectors      CollectorsConfig `yaml:"collectors"`       //Which collectors run and how often
+	Paths           PathsConfig      `yaml:"paths"`            //Files and directories used by the server
+	History         HistoryConfig    `yaml:"history"`          //In-memory and on-disk history
+	Limits          LimitsConfig     `yaml:"limits"`           //Resource limits
+	Auth            AuthConfig       `yaml:"auth"`             //Users authentication
+	TLS             TLSConfig        `yaml:"tls"`              //HTTPS and client certificates
+	Log             LogConfig        `yaml:"log"`              //Diagnostics logging
+}
+
+type CollectorsConfig struct {
+	Enabled   []string                 `yaml:"enabled"`   //Names of the collectors to run, all of them if empty
+	Intervals map[string]time.Duration `yaml:"intervals"` //Collector name -> minimum time between two collections
+	Timeouts  map[string]time.Duration `yaml:"timeouts"`  //Collector name -> collection timeout
+
+	NormalizeProcessCPU bool `yaml:"normalize_process_cpu"` //Divide the CPU usage of the processes by the number of cores
+}
+
+type PathsConfig struct {
+	Templates  string `yaml:"templates"`   //Directory overriding the embedded HTML templates, empty for none
+	Static     string `yaml:"static"`      //Directory overriding the embedded web page and static resources, empty for none
+	Data       string `yaml:"data"`        //Directory of the on-disk history
+	AlertRules string `yaml:"alert_rules"` //Alert rules file
+	Notify     string `yaml:"notify"`      //Notification configuration file
+	Users      string `yaml:"users"`       //Users file (hashed passwords, API tokens and roles)
+	Audit      string `yaml:"audit"`       //Audit log of the process actions (JSON lines)
+}
+
+type HistoryConfig struct {
+	Retention time.Duration `yaml:"retention"` //How long the in-memory history keep the samples
+	Persist   bool          `yaml:"persist"`   //Whether the samples are also written to disk
+}
+
+type LimitsConfig struct {
+	ClientQueue      int    `yaml:"client_queue"`       //Messages queued per websocket client before the slow client policy apply
+	SlowClientPolicy string `yaml:"slow_client_policy"` //drop_oldest or disconnect
+	MaxProcessSeries int    `yaml:"max_process_series"` //Processes exported to /metrics and kept in the history
+}
+
+type AuthConfig struct {
+	Enabled        bool          `yaml:"enabled"`         //Require a login (or an API token) for every request
+	SessionTTL     time.Duration `yaml:"session_ttl"`     //Lifetime of a web UI session
+	AllowedOrigins []string      `yaml:"allowed_origins"` //Origins allowed to open a websocket, besides the server's own
+}
+
+type TLSConfig struct {
+	Cert       string `yaml:"cert"`        //Certificate chain (PEM), HTTPS is enabled when set
+	Key        string `yaml:"key"`         //Private key of the certificate (PEM)
+	ClientCA   string `yaml:"client_ca"`   //CA bundle verifying the client certificates (mutual TLS), disabled if empty
+	ClientAuth string `yaml:"client_auth"` //require (default) or optional: whether clients without certificate are accepted
+}
+
+type LogConfig struct {
+	Level      string `yaml:"level"`       //debug, info, warn or error
+	Format     string `yaml:"format"`      //text or json
+	File       string `yaml:"file"`        //Log file, standard output if empty
+	MaxSizeMB  int    `yaml:"max_size_mb"` //Size from which the log file is rotated (0 = never)
+	MaxBackups int    `yaml:"max_backups"` //Rotated files kept (<file>.1 is the most recent)
+}
+
+// Whether the server is served over HTTPS
+func (tlsConfig TLSConfig) Enabled() bool {
+	return tlsConfig.Cert != ""
+}
+
+const DEFAULT_CONFIG_FILE = "./sysmon.yaml"
+
+func Default() *Config {
+	return &Config{
+		Listen:          ":8800",
+		Interval:        time.Second,
+		ShutdownTimeout: 10 * time.Second,
+		Collectors: CollectorsConfig{
+			Intervals: map[string]time.Duration{},
+			Timeouts:  map[string]time.Duration{},
+		},
+		Paths: PathsConfig{
+			Data:       "./data",
+			AlertRules: "./alerts.json",
+			Notify:     "./notify.json",
+			Users:      "./users.json",
+			Audit:      "./audit.log",
+		},
+		History: HistoryConfig{
+			Retention: time.Hour,
+			Persist:   true,
+		},
+		Limits: LimitsConfig{
+			ClientQueue:      16,
+			SlowClientPolicy: "drop_oldest",
+			MaxProcessSeries: 50,
+		},
+		Auth: AuthConfig{
+			Enabled:    true,
+			SessionTTL: 12 * time.Hour,
+		},
+		TLS: TLSConfig{
+			ClientAuth: "require",
+		},
+		Log: LogConfig{
+			Level:      "info",
+			Format:     "text",
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+		},
+	}
+}
+
+// Build the configuration from the command-line arguments (without the program name) and the environment
+func Load(args []string) (*Config, error) {
+	config := Default()
+
+	//The flags are parsed first to find the configuration file, but applied last
+	flags := flag.NewFlagSet("sysmon", flag.ContinueOnError)
+	var (
+		configFile = flags.String("config", "", "YAML configuration file (default ./sysmon.yaml if it exists)")
+		listen     = flags.String("listen", "", "address to listen on, ex: :8800")
+		interval   = flags.Duration("interval", 0, "interval of the collection loop, ex: 1s")
+		collectors = flags.String("collectors", "", "comma separated list of enabled collectors")
+		templates  = flags.String("templates", "", "directory overriding the embedded HTML templates")
+		static     = flags.String("static", "", "directory overriding the embedded web page and static resources")
+		data       = flags.String("data", "", "directory of the on-disk history")
+		tlsCert    = flags.String("tls-cert", "", "certificate file (PEM), enable HTTPS")
+		tlsKey     = flags.String("tls-key", "", "private key file (PEM) of the certificate")
+		logLevel   = flags.String("log-level", "", "log level: debug, info, warn or error")
+		logFormat  = flags.String("log-format", "", "log format: text or json")
+		logFile    = flags.String("log-file", "", "log file, standard output if empty")
+		dev        = flags.Bool("dev", false, "development mode, reload the templates when they change")
+	)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	//Configuration file
+	path := firstNonEmpty(*configFile, os.Getenv("SYSMON_CONFIG"))
+	required := path != ""
+	if path == "" {
+		path = DEFAULT_CONFIG_FILE
+	}
+	if err := config.loadFile(path, required); err != nil {
+		return nil, err
+	}
+
+	//Environment variables
+	if err := config.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	//Flags, only the ones explicitly set override the previous values
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			config.Listen = *listen
+		case "interval":
+			config.Interval = *interval
+		case "collectors":
+			config.Collectors.Enabled = splitList(*collectors)
+		case "templates":
+			config.Paths.Templates = *templates
+		case "static":
+			config.Paths.Static = *static
+		case "data":
+			config.Paths.Data = *data
+		case "tls-cert":
+			config.TLS.Cert = *tlsCert
+		case "tls-key":
+			config.TLS.Key = *tlsKey
+		case "log-level":
+			config.Log.Level = *logLevel
+		case "log-format":
+			config.Log.Format = *logFormat
+		case "log-file":
+			config.Log.File = *logFile
+		case "dev":
+			config.Dev = *dev
+		}
+	})
+
+	return config, config.Validate()
+}
+
+// Read the YAML file on top of the current values. A missing file is only an error if it was explicitly requested
+func (config *Config) loadFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true) //Catch typos in the configuration file
+	if err = decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// Apply the SYSMON_* environment variables
+func (config *Config) loadEnv() error {
+	values := map[string]*string{
+		"SYSMON_LISTEN":      &config.Listen,
+		"SYSMON_TEMPLATES":   &config.Paths.Templates,
+		"SYSMON_STATIC":      &config.Paths.Static,
+		"SYSMON_DATA":        &config.Paths.Data,
+		"SYSMON_ALERT_RULES": &config.Paths.AlertRules,
+		"SYSMON_NOTIFY":      &config.Paths.Notify,
+		"SYSMON_USERS":       &config.Paths.Users,
+		"SYSMON_AUDIT":       &config.Paths.Audit,
+		"SYSMON_TLS_CERT":    &config.TLS.Cert,
+		"SYSMON_TLS_KEY":     &config.TLS.Key,
+		"SYSMON_TLS_CA":      &config.TLS.ClientCA,
+		"SYSMON_LOG_LEVEL":   &config.Log.Level,
+		"SYSMON_LOG_FORMAT":  &config.Log.Format,
+		"SYSMON_LOG_FILE":    &config.Log.File,
+	}
+	for name, target := range values {
+		if value, ok := os.LookupEnv(name); ok {
+			*target = value
+		}
+	}
+
+	if value, ok := os.LookupEnv("SYSMON_COLLECTORS"); ok {
+		config.Collectors.Enabled = splitList(value)
+	}
+
+	durations := map[string]*time.Duration{
+		"SYSMON_INTERVAL":          &config.Interval,
+		"SYSMON_SHUTDOWN_TIMEOUT":  &config.ShutdownTimeout,
+		"SYSMON_HISTORY_RETENTION": &config.History.Retention,
+	}
+	for name, target := range durations {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*target = parsed
+		}
+	}
+
+	booleans := map[string]*bool{
+		"SYSMON_HISTORY_PERSIST":       &config.History.Persist,
+		"SYSMON_AUTH_ENABLED":          &config.Auth.Enabled,
+		"SYSMON_DEV":                   &config.Dev,
+		"SYSMON_NORMALIZE_PROCESS_CPU": &config.Collectors.NormalizeProcessCPU,
+	}
+	for name, target := range booleans {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*target = parsed
+		}
+	}
+
+	return nil
+}
+
+func (config *Config) Validate() error {
+	if config.Listen == "" {
+		return errors.New("listen address cannot be empty")
+	}
+	if config.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	if config.ShutdownTimeout <= 0 {
+		return errors.New("shutdown timeout must be positive")
+	}
+
+	registered := hardware.Registered()
+	check := func(name string) error {
+		if !slices.Contains(registered, name) {
+			return fmt.Errorf("unknown collector %s (available: %s)", name, strings.Join(registered, ", "))
+		}
+		return nil
+	}
+	for _, name := range config.Collectors.Enabled {
+		if err := check(name); err != nil {
+			return err
+		}
+	}
+	for name, interval := range config.Collectors.Intervals {
+		if err := check(name); err != nil {
+			return err
+		}
+		if interval < 0 {
+			return fmt.Errorf("interval of collector %s cannot be negative", name)
+		}
+	}
+	for name, timeout := range config.Collectors.Timeouts {
+		if err := check(name); err != nil {
+			return err
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("timeout of collector %s must be positive", name)
+		}
+	}
+
+	if config.History.Retention < config.Interval {
+		return errors.New("history retention must be at least one interval")
+	}
+	if config.Limits.ClientQueue <= 0 {
+		return errors.New("client queue size must be positive")
+	}
+	if config.Limits.SlowClientPolicy != "drop_oldest" && config.Limits.SlowClientPolicy != "disconnect" {
+		return fmt.Errorf("invalid slow client policy %q (drop_oldest or disconnect)", config.Limits.SlowClientPolicy)
+	}
+	if config.Limits.MaxProcessSeries < 0 {
+		return errors.New("max process series cannot be negative")
+	}
+	if config.Auth.Enabled && config.Auth.SessionTTL <= 0 {
+		return errors.New("session ttl must be positive")
+	}
+	if (config.TLS.Cert == "") != (config.TLS.Key == "") {
+		return errors.New("tls cert and key must be set together")
+	}
+	if config.TLS.ClientCA != "" && !config.TLS.Enabled() {
+		return errors.New("tls client ca requires a tls cert and key")
+	}
+	if config.TLS.ClientAuth != "require" && config.TLS.ClientAuth != "optional" {
+		return fmt.Errorf("invalid tls client auth %q (require or optional)", config.TLS.ClientAuth)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Log.Level)); err != nil {
+		return fmt.Errorf("invalid log level %q (debug, info, warn or error)", config.Log.Level)
+	}
+	if config.Log.Format != "text" && config.Log.Format != "json" {
+		return fmt.Errorf("invalid log format %q (text or json)", config.Log.Format)
+	}
+	if config.Log.MaxSizeMB < 0 || config.Log.MaxBackups < 0 {
+		return errors.New("log max size and max backups cannot be negative")
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Split a comma separated list, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,75 @@
+package hardware
+
+import (
+	"fmt"
+	"html/template"
+	"slices"
+	"sync"
+)
+
+/*
+ * A Collector is a source of hardware data that Hardware iterates over.
+ * Built-in collectors (system, disks, cpu, processes, connections) are registered in this package,
+ * other packages can add their own by calling Register in their init function:
+ *
+ *	func init() {
+ *		hardware.Register("gpu", func() hardware.Collector { return NewGpuInfo() })
+ *	}
+ */
+type Collector interface {
+	Name() string                   //Unique name, used as the key in the registry, the template and the API
+	Collect() error                 //Refresh the data
+	Render() (template.HTML, error) //Return the HTML fragment representing the data
+	Serialize() ([]byte, error)     //Return the JSON representation of the data
+}
+
+// Function used to create a new instance of a collector
+type CollectorFactory func() Collector
+
+// The registry keep the registration order, which is also the order the collectors are displayed
+var registry = struct {
+	sync.Mutex
+	names     []string
+	factories map[string]CollectorFactory
+}{
+	factories: make(map[string]CollectorFactory),
+}
+
+// Register a collector factory under a unique name. Panic if the name is already taken
+func Register(name string, factory CollectorFactory) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if factory == nil {
+		panic("hardware: Register collector factory is nil")
+	}
+	if _, exist := registry.factories[name]; exist {
+		panic(fmt.Sprintf("hardware: Register called twice for collector %s", name))
+	}
+
+	registry.names = append(registry.names, name)
+	registry.factories[name] = factory
+}
+
+// Return the names of all registered collectors, in registration order
+func Registered() []string {
+	registry.Lock()
+	defer registry.Unlock()
+
+	return append([]string(nil), registry.names...)
+}
+
+// Create a new instance of the given registered collectors (all of them if names is empty), in registration order
+func newCollectors(names []string) []Collector {
+	registry.Lock()
+	defer registry.Unlock()
+
+	collectors := make([]Collector, 0, len(registry.names))
+	for _, name := range registry.names {
+		if len(names) > 0 && !slices.Contains(names, name) {
+			continue
+		}
+		collectors = append(collectors, registry.factories[name]())
+	}
+	return collectors
+}
@@ -1,25 +1,39 @@
 package hardware
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
-	"time"
 
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/load"
 )
 
+// Share of the CPU time spent in each state between two samples, in percent
+type CpuBreakdown struct {
+	User    float64 `json:"user"`    //Running user space code (including nice and guest)
+	System  float64 `json:"system"`  //Running kernel code
+	Iowait  float64 `json:"iowait"`  //Idle while waiting for I/O
+	Irq     float64 `json:"irq"`     //Servicing hardware interrupts
+	Softirq float64 `json:"softirq"` //Servicing software interrupts
+	Steal   float64 `json:"steal"`   //Stolen by the hypervisor for other virtual machines
+	Idle    float64 `json:"idle"`    //Doing nothing
+}
+
 type CpuInfo struct {
-	Model         string    //Model name of the CPU
-	Family        string    //Model family of the CPU
-	MHz           float64   //CPU running frequency
-	CacheSize     uint64    //Cache size
-	TotalUsage    float64   //Total CPU usage
-	UsagePerCores []float64 //Each core usage
-	Load1         float64   //Average load (short-term load)
-	Load5         float64   //Average load (mid-term load)
-	Load15        float64   //Average load (long-term load)
+	Model         string       `json:"model"`                  //Model name of the CPU
+	Family        string       `json:"family"`                 //Model family of the CPU
+	MHz           float64      `json:"mhz"`                    //CPU running frequency
+	CacheSize     uint64       `json:"cache_size_kb"`          //Cache size (gopsutil report it in KB)
+	TotalUsage    float64      `json:"total_usage_percent"`    //Total CPU usage
+	UsagePerCores []float64    `json:"usage_per_core_percent"` //Each core usage
+	Breakdown     CpuBreakdown `json:"breakdown_percent"`      //Total CPU time split by state
+	Load1         float64      `json:"load1"`                  //Average load (short-term load)
+	Load5         float64      `json:"load5"`                  //Average load (mid-term load)
+	Load15        float64      `json:"load15"`                 //Average load (long-term load)
+
+	prevTotal *cpu.TimesStat  //CPU times of the previous sample, used to compute the usage from the delta
+	prevCores []cpu.TimesStat //CPU times of each core at the previous sample
 }
 
 func NewCpuInfo() *CpuInfo {
@@ -34,6 +48,9 @@ func (cpuInfo *CpuInfo) String() string {
 	str += fmt.Sprintf("Run at: %.2f MHz\n", cpuInfo.MHz)
 	str += fmt.Sprintf("Cache size: %s\n", ConvertByte(cpuInfo.CacheSize))
 	str += fmt.Sprintf("Total CPU usage: %.2f%%\n", cpuInfo.TotalUsage)
+	str += fmt.Sprintf("Breakdown: user %.2f%%, system %.2f%%, iowait %.2f%%, irq %.2f%%, softirq %.2f%%, steal %.2f%%\n",
+		cpuInfo.Breakdown.User, cpuInfo.Breakdown.System, cpuInfo.Breakdown.Iowait,
+		cpuInfo.Breakdown.Irq, cpuInfo.Breakdown.Softirq, cpuInfo.Breakdown.Steal)
 	str += "Usage per cores: \n"
 	for core, usage := range cpuInfo.UsagePerCores {
 		str += fmt.Sprintf("\tCore %d: %.2f%%\n", core, usage)
@@ -42,28 +59,71 @@ func (cpuInfo *CpuInfo) String() string {
 	return str
 }
 
-func (cpuInfo *CpuInfo) ToHtml(tmplPath string) (string, error) {
-	//Func map
-	funcMap := template.FuncMap{
-		"ConvertByte": ConvertByte,
+func (cpuInfo *CpuInfo) ToHtml() (string, error) {
+	html, err := ExecuteTemplate(CPU_TMPL, cpuInfo)
+	return string(html), err
+}
+
+/*---Collector interface---*/
+
+func (cpuInfo *CpuInfo) Name() string {
+	return CPU_COLLECTOR
+}
+
+func (cpuInfo *CpuInfo) Collect() error {
+	return cpuInfo.GetCPUInfo()
+}
+
+func (cpuInfo *CpuInfo) Render() (template.HTML, error) {
+	html, err := cpuInfo.ToHtml()
+	return template.HTML(html), err
+}
+
+func (cpuInfo *CpuInfo) Serialize() ([]byte, error) {
+	return json.Marshal(cpuInfo)
+}
+
+/*
+ * Return the total time of a sample and the time spent working.
+ * On Linux guest time is already accounted in user time, so it is not added again
+ */
+func cpuTimes(times cpu.TimesStat) (total, busy float64) {
+	total = times.User + times.Nice + times.System + times.Idle + times.Iowait + times.Irq + times.Softirq + times.Steal
+	busy = total - times.Idle - times.Iowait
+	return total, busy
+}
+
+// Compute the usage between two samples. Without a previous sample, the usage since boot is returned
+func cpuUsage(prev *cpu.TimesStat, curr cpu.TimesStat) (float64, CpuBreakdown) {
+	if prev == nil {
+		prev = &cpu.TimesStat{}
 	}
 
-	//Get the template
-	tmpl, err := template.New("cpuTmpl.html").Funcs(funcMap).ParseFiles(tmplPath)
-	if err != nil {
-		return "", err
+	currTotal, currBusy := cpuTimes(curr)
+	prevTotal, prevBusy := cpuTimes(*prev)
+	delta := currTotal - prevTotal
+	if delta <= 0 {
+		//No time elapsed (or the counters were reset), nothing meaningful to report
+		return 0, CpuBreakdown{}
 	}
 
-	//Execute template
-	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, cpuInfo)
-	if err != nil {
-		return "", err
+	percent := func(currValue, prevValue float64) float64 {
+		return max(0, min(100, (currValue-prevValue)/delta*100))
 	}
-	return buffer.String(), nil
+
+	breakdown := CpuBreakdown{
+		User:    percent(curr.User+curr.Nice, prev.User+prev.Nice),
+		System:  percent(curr.System, prev.System),
+		Iowait:  percent(curr.Iowait, prev.Iowait),
+		Irq:     percent(curr.Irq, prev.Irq),
+		Softirq: percent(curr.Softirq, prev.Softirq),
+		Steal:   percent(curr.Steal, prev.Steal),
+		Idle:    percent(curr.Idle, prev.Idle),
+	}
+	return percent(currBusy, prevBusy), breakdown
 }
 
-func (cpuInfo *CpuInfo) GetCPUInfo(interval time.Duration) error {
+func (cpuInfo *CpuInfo) GetCPUInfo() error {
 	//Get the CPU status
 	cpuStat, err := cpu.Info()
 	if err != nil {
@@ -81,27 +141,41 @@ func (cpuInfo *CpuInfo) GetCPUInfo(interval time.Duration) error {
 	cpuInfo.CacheSize = uint64(cpuStat[0].CacheSize)
 
 	/*
-	 * Get CPU usage:
-	 * 1. The first paramter is the interval for which the package used to compare to get the usage
-	 * If interval is 0, it will compare the value to the last call. If provide interval value, it will
-	 * wait for an interval before calculating usage
-	 * 2. The second parameter indicate if we want to get total CPU usage (false), or usage per core (true)
+	 * Get CPU usage: instead of calling cpu.Percent with an interval (which sleep during that interval),
+	 * we read the cumulative CPU times and compare them with the ones of the previous collection.
+	 * This way the usage cover exactly the time between two ticks and the collection never block
 	 */
 
 	//Get total cpu usage
-	totalUsage, err := cpu.Percent(time.Second, false)
+	totalTimes, err := cpu.Times(false)
 	if err != nil {
 		return err
 	}
-	cpuInfo.TotalUsage = totalUsage[0]
+	if len(totalTimes) == 0 {
+		return fmt.Errorf("no CPU times available")
+	}
+	cpuInfo.TotalUsage, cpuInfo.Breakdown = cpuUsage(cpuInfo.prevTotal, totalTimes[0])
+	cpuInfo.prevTotal = &totalTimes[0]
 
 	//Get each core's usage
-	coresUsage, err := cpu.Percent(interval, true)
+	coresTimes, err := cpu.Times(true)
 	if err != nil {
 		return err
 	}
-	cpuInfo.UsagePerCores = nil //Clear all remaining data before appending
-	cpuInfo.UsagePerCores = append(cpuInfo.UsagePerCores, coresUsage...)
+	if len(coresTimes) != len(cpuInfo.prevCores) {
+		//First sample or a core has been (un)plugged, start over
+		cpuInfo.prevCores = nil
+	}
+	cpuInfo.UsagePerCores = cpuInfo.UsagePerCores[:0] //Clear all remaining data before appending
+	for core, times := range coresTimes {
+		var prev *cpu.TimesStat
+		if cpuInfo.prevCores != nil {
+			prev = &cpuInfo.prevCores[core]
+		}
+		usage, _ := cpuUsage(prev, times)
+		cpuInfo.UsagePerCores = append(cpuInfo.UsagePerCores, usage)
+	}
+	cpuInfo.prevCores = coresTimes
 
 	/*
 	 * Get the average load: Average load (or load average) is a measure of system activity over a period of time.
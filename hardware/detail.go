@@ -0,0 +1,219 @@
+package hardware
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// Process states, from the first letter of /proc/<pid>/stat
+var ProcessStates = map[string]string{
+	"R": "running",
+	"S": "sleeping",
+	"D": "disk sleep",
+	"Z": "zombie",
+	"T": "stopped",
+	"t": "tracing stop",
+	"X": "dead",
+	"I": "idle",
+	"W": "paging",
+}
+
+type OpenFile struct {
+	FD   uint64 `json:"fd"`
+	Path string `json:"path"`
+}
+
+type Cgroup struct {
+	Hierarchy   string `json:"hierarchy"`   //Hierarchy ID, 0 for the unified cgroup v2 hierarchy
+	Controllers string `json:"controllers"` //Comma separated controllers, empty for cgroup v2
+	Path        string `json:"path"`        //Path of the cgroup inside the hierarchy
+}
+
+/*
+ * Everything we can read about one process, on demand. Fields that cannot be read (usually permission denied
+ * on a process of another user when not running as root) are left empty and the reason is in Errors
+ */
+type ProcessDetail struct {
+	PID           int32             `json:"pid"`
+	PPID          int32             `json:"ppid"`
+	Name          string            `json:"name"`
+	Cmdline       []string          `json:"cmdline"`
+	Exe           string            `json:"exe"`
+	Cwd           string            `json:"cwd"`
+	User          string            `json:"user"`
+	UID           int32             `json:"uid"`
+	Group         string            `json:"group"`
+	GID           int32             `json:"gid"`
+	State         string            `json:"state"`
+	Nice          int32             `json:"nice"`
+	Priority      int32             `json:"priority"`
+	StartTime     time.Time         `json:"start_time"`
+	UserSeconds   float64           `json:"cpu_user_seconds"`
+	SystemSeconds float64           `json:"cpu_system_seconds"`
+	IowaitSeconds float64           `json:"cpu_iowait_seconds"`
+	Threads       int32             `json:"threads"`
+	MemoryUsed    uint64            `json:"rss_bytes"`
+	VirtualMemory uint64            `json:"vms_bytes"`
+	SwapUsed      uint64            `json:"swap_bytes"`
+	SharedMemory  uint64            `json:"shared_bytes"`
+	FDCount       int32             `json:"fd_count"`
+	OpenFiles     []OpenFile        `json:"open_files"`
+	Environment   []string          `json:"environment,omitempty"` //Only when requested, it often contains secrets
+	Cgroups       []Cgroup          `json:"cgroups"`
+	Children      []int32           `json:"children"`
+	Errors        map[string]string `json:"errors,omitempty"` //Field -> why it could not be read
+}
+
+// Read the details of a running process, with its environment if withEnv is set. Fail if the process does not exist
+func GetProcessDetail(pid int32, withEnv bool) (*ProcessDetail, error) {
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &ProcessDetail{PID: pid, Errors: map[string]string{}}
+	check := func(field string, err error) {
+		if err != nil {
+			detail.Errors[field] = err.Error()
+		}
+	}
+
+	detail.Name, err = proc.Name()
+	check("name", err)
+	detail.PPID, err = proc.Ppid()
+	check("ppid", err)
+	detail.Cmdline, err = proc.CmdlineSlice()
+	check("cmdline", err)
+	detail.Exe, err = proc.Exe()
+	check("exe", err)
+	detail.Cwd, err = proc.Cwd()
+	check("cwd", err)
+
+	//Owner, the real user and group
+	if uids, err := proc.Uids(); err != nil {
+		check("user", err)
+	} else if len(uids) > 0 {
+		detail.UID = uids[0]
+		if owner, err := user.LookupId(strconv.Itoa(int(detail.UID))); err == nil {
+			detail.User = owner.Username
+		} else {
+			detail.User = strconv.Itoa(int(detail.UID))
+		}
+	}
+	if gids, err := proc.Gids(); err != nil {
+		check("group", err)
+	} else if len(gids) > 0 {
+		detail.GID = gids[0]
+		if group, err := user.LookupGroupId(strconv.Itoa(int(detail.GID))); err == nil {
+			detail.Group = group.Name
+		} else {
+			detail.Group = strconv.Itoa(int(detail.GID))
+		}
+	}
+
+	if state, err := proc.Status(); err != nil {
+		check("state", err)
+	} else if name, ok := ProcessStates[state]; ok {
+		detail.State = name
+	} else {
+		detail.State = state
+	}
+	detail.Priority, detail.Nice, err = readScheduling(pid)
+	check("priority", err)
+
+	if created, err := proc.CreateTime(); err != nil {
+		check("start_time", err)
+	} else {
+		detail.StartTime = time.UnixMilli(created)
+	}
+	if times, err := proc.Times(); err != nil {
+		check("cpu_times", err)
+	} else {
+		detail.UserSeconds, detail.SystemSeconds, detail.IowaitSeconds = times.User, times.System, times.Iowait
+	}
+	detail.Threads, err = proc.NumThreads()
+	check("threads", err)
+
+	if memory, err := proc.MemoryInfo(); err != nil {
+		check("memory", err)
+	} else {
+		detail.MemoryUsed, detail.VirtualMemory, detail.SwapUsed = memory.RSS, memory.VMS, memory.Swap
+	}
+	if memory, err := proc.MemoryInfoEx(); err != nil {
+		check("shared_memory", err)
+	} else {
+		detail.SharedMemory = memory.Shared
+	}
+
+	detail.FDCount, err = proc.NumFDs()
+	check("fd_count", err)
+	detail.OpenFiles = []OpenFile{}
+	if files, err := proc.OpenFiles(); err != nil {
+		check("open_files", err)
+	} else {
+		for _, file := range files {
+			detail.OpenFiles = append(detail.OpenFiles, OpenFile{FD: file.Fd, Path: file.Path})
+		}
+	}
+
+	if withEnv {
+		detail.Environment, err = proc.Environ()
+		check("environment", err)
+	}
+
+	detail.Cgroups, err = readCgroups(pid)
+	check("cgroups", err)
+
+	detail.Children, err = Children(pid)
+	check("children", err)
+
+	if len(detail.Errors) == 0 {
+		detail.Errors = nil
+	}
+	return detail, nil
+}
+
+// Kernel scheduling priority and nice value, fields 18 and 19 of /proc/<pid>/stat (gopsutil return the priority as the nice value)
+func readScheduling(pid int32) (int32, int32, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	//The name (field 2) is between parentheses and may contain spaces, the fields after it start at field 3
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 17 {
+		return 0, 0, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	priority, err := strconv.ParseInt(fields[15], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	nice, err := strconv.ParseInt(fields[16], 10, 32)
+	return int32(priority), int32(nice), err
+}
+
+// Cgroups of the process, one per hierarchy (a single one with cgroup v2)
+func readCgroups(pid int32) ([]Cgroup, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return []Cgroup{}, err
+	}
+
+	cgroups := []Cgroup{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		//hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) == 3 {
+			cgroups = append(cgroups, Cgroup{Hierarchy: parts[0], Controllers: parts[1], Path: parts[2]})
+		}
+	}
+	return cgroups, nil
+}
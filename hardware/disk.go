@@ -1,7 +1,7 @@
 package hardware
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
 
@@ -9,9 +9,10 @@ import (
 )
 
 type PartitionInfo struct {
-	DeviceName string //Curent partition
-	Total      uint64 //Total size
-	Free       uint64 //Free storage remain
+	DeviceName string `json:"device"`      //Curent partition
+	Mountpoint string `json:"mountpoint"`  //Where it is mounted, a device can be mounted several times
+	Total      uint64 `json:"total_bytes"` //Total size
+	Free       uint64 `json:"free_bytes"`  //Free storage remain
 }
 
 func NewPartitionInfo() *PartitionInfo {
@@ -39,25 +40,28 @@ func (diskInfo *DiskInfo) String() string {
 	return str
 }
 
-func (diskInfo *DiskInfo) ToHtml(tmplPath string) (string, error) {
-	//Func map
-	funcMap := template.FuncMap{
-		"ConvertByte": ConvertByte,
-	}
+func (diskInfo *DiskInfo) ToHtml() (string, error) {
+	html, err := ExecuteTemplate(DISK_TMPL, diskInfo)
+	return string(html), err
+}
 
-	//Get the template
-	tmpl, err := template.New("diskTmpl.html").Funcs(funcMap).ParseFiles(tmplPath)
-	if err != nil {
-		return "", err
-	}
+/*---Collector interface---*/
 
-	//Execute template
-	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, diskInfo)
-	if err != nil {
-		return "", err
-	}
-	return buffer.String(), nil
+func (diskInfo *DiskInfo) Name() string {
+	return DISK_COLLECTOR
+}
+
+func (diskInfo *DiskInfo) Collect() error {
+	return diskInfo.GetDiskInfo()
+}
+
+func (diskInfo *DiskInfo) Render() (template.HTML, error) {
+	html, err := diskInfo.ToHtml()
+	return template.HTML(html), err
+}
+
+func (diskInfo *DiskInfo) Serialize() ([]byte, error) {
+	return json.Marshal(diskInfo)
 }
 
 func (diskInfo *DiskInfo) GetDiskInfo() error {
@@ -82,6 +86,7 @@ func (diskInfo *DiskInfo) GetDiskInfo() error {
 			return err
 		}
 		parInfo.DeviceName = partition.Device
+		parInfo.Mountpoint = partition.Mountpoint
 		parInfo.Total = diskStat.Total
 		parInfo.Free = diskStat.Free
 
@@ -1,132 +1,409 @@
 package hardware
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"log/slog"
+	"sync"
+	"time"
 )
 
+// Name of the built-in collectors
 const (
-	SYSTEM_TMPL  = "./templates/systemTmpl.html"
-	DISK_TMPL    = "./templates/diskTmpl.html"
-	CPU_TMPL     = "./templates/cpuTmpl.html"
-	PROCESS_TMPL = "./templates/processTmpl.html"
-	NET_TMPL     = "./templates/netTmpl.html"
-	TMPL         = "./templates/tmpl.html"
+	SYSTEM_COLLECTOR  = "system"
+	DISK_COLLECTOR    = "disks"
+	CPU_COLLECTOR     = "cpu"
+	PROCESS_COLLECTOR = "processes"
+	NET_COLLECTOR     = "connections"
 )
 
+// Section of the page showing the health of the collectors
+const STATUS_SECTION = "status"
+
+func init() {
+	//Register the built-in collectors, the order here is the order they are displayed
+	Register(SYSTEM_COLLECTOR, func() Collector { return NewSystemInfo() })
+	Register(DISK_COLLECTOR, func() Collector { return NewDiskInfo() })
+	Register(CPU_COLLECTOR, func() Collector { return NewCpuInfo() })
+	Register(PROCESS_COLLECTOR, func() Collector { return NewProcessCollector() })
+	Register(NET_COLLECTOR, func() Collector { return NewConnections() })
+}
+
+// Maximum time a collector is allowed to run before its collection is considered failed
+const DEFAULT_COLLECT_TIMEOUT = 3 * time.Second
+
+// Health of a single collector, exposed in the dashboard and the API
+type CollectorStatus struct {
+	Name           string    `json:"name"`
+	Healthy        bool      `json:"healthy"`              //False if the last collection failed or timed out
+	LastError      string    `json:"last_error,omitempty"` //Error of the last failed collection
+	LastErrorAt    time.Time `json:"last_error_at"`        //Time of the last failed collection
+	LastSuccess    time.Time `json:"last_success"`         //Time of the last successful collection (zero if never)
+	LastDurationMs float64   `json:"last_duration_ms"`     //Duration of the last finished collection, in milliseconds
+}
+
+// Book keeping of a collector inside Hardware
+type collectorState struct {
+	busy      sync.Mutex    //Held while the collector is collecting, so a slow collector is never run twice at the same time
+	collector Collector     //The collector itself, only touched by the goroutine holding busy
+	timeout   time.Duration //Per collector timeout
+	interval  time.Duration //Minimum time between two collections, 0 to collect on every call to CollectData
+	lastRun   time.Time     //When the last collection started
+	html      template.HTML //Last successfully rendered HTML (guarded by Hardware.lock)
+	json      []byte        //Last successfully serialized JSON (guarded by Hardware.lock)
+	rows      []Row         //Rows of the last successful render of a TableCollector (guarded by Hardware.lock)
+	status    CollectorStatus
+}
+
 type Hardware struct {
+	//Typed shortcuts to the built-in collectors. They are mutated during collection,
+	//so concurrent readers should use Snapshot instead
 	SysInfo     *SystemInfo
 	DiskInfo    *DiskInfo
 	CpuInfo     *CpuInfo
-	ProcessInfo *Processes
+	ProcessInfo *ProcessCollector
 	NetInfo     *Connections
+
+	lock        sync.RWMutex      //Guard the cached results and status of every collector
+	states      []*collectorState //Every registered collector, in registration order
+	lastCollect time.Time         //When the last call to CollectData started (guarded by lock)
+}
+
+// Create the hardware with the given collectors (every registered collector if none is given)
+func NewHardware(names ...string) *Hardware {
+	hardware := &Hardware{}
+
+	for _, collector := range newCollectors(names) {
+		hardware.states = append(hardware.states, &collectorState{
+			collector: collector,
+			timeout:   DEFAULT_COLLECT_TIMEOUT,
+			status:    CollectorStatus{Name: collector.Name()},
+		})
+
+		//Keep a typed pointer to the built-in collectors so callers don't have to type assert
+		switch c := collector.(type) {
+		case *SystemInfo:
+			hardware.SysInfo = c
+		case *DiskInfo:
+			hardware.DiskInfo = c
+		case *CpuInfo:
+			hardware.CpuInfo = c
+		case *ProcessCollector:
+			hardware.ProcessInfo = c
+		case *Connections:
+			hardware.NetInfo = c
+		}
+	}
+
+	return hardware
+}
+
+// Return all the collectors, in registration order
+func (hardware *Hardware) Collectors() []Collector {
+	collectors := make([]Collector, 0, len(hardware.states))
+	for _, state := range hardware.states {
+		collectors = append(collectors, state.collector)
+	}
+	return collectors
+}
+
+// Return the collector with the given name, or nil if there is none
+func (hardware *Hardware) Collector(name string) Collector {
+	if state := hardware.state(name); state != nil {
+		return state.collector
+	}
+	return nil
 }
 
-func NewHardware() *Hardware {
-	return &Hardware{
-		SysInfo:     NewSystemInfo(),
-		DiskInfo:    NewDiskInfo(),
-		CpuInfo:     NewCpuInfo(),
-		ProcessInfo: NewProcesses(),
-		NetInfo:     NewConnections(),
+func (hardware *Hardware) state(name string) *collectorState {
+	for _, state := range hardware.states {
+		if state.collector.Name() == name {
+			return state
+		}
 	}
+	return nil
+}
+
+// Change the timeout of a collector. Must be called before the collection starts
+func (hardware *Hardware) SetTimeout(name string, timeout time.Duration) error {
+	state := hardware.state(name)
+	if state == nil {
+		return fmt.Errorf("unknown collector %s", name)
+	}
+	state.timeout = timeout
+	return nil
+}
+
+// Change the minimum time between two collections of a collector. Must be called before the collection starts
+func (hardware *Hardware) SetInterval(name string, interval time.Duration) error {
+	state := hardware.state(name)
+	if state == nil {
+		return fmt.Errorf("unknown collector %s", name)
+	}
+	state.interval = interval
+	return nil
+}
+
+// Return the JSON of the last successful collection of a collector, or nil if it never succeeded
+func (hardware *Hardware) Snapshot(name string) []byte {
+	state := hardware.state(name)
+	if state == nil {
+		return nil
+	}
+
+	hardware.lock.RLock()
+	defer hardware.lock.RUnlock()
+	return state.json
+}
+
+// Decode the last successful collection of a collector into value, return false if there is none.
+// The value is a copy, so it is safe to read while the collector is collecting again
+func (hardware *Hardware) Decode(name string, value any) bool {
+	data := hardware.Snapshot(name)
+	if data == nil {
+		return false
+	}
+	return json.Unmarshal(data, value) == nil
+}
+
+/*
+ * Same as Decode, but only if the collector succeeded during the last call to CollectData.
+ * Return false for a collector that failed, timed out or was skipped because of its interval, so its old data
+ * is not mistaken for a new value
+ */
+func (hardware *Hardware) DecodeFresh(name string, value any) bool {
+	state := hardware.state(name)
+	if state == nil {
+		return false
+	}
+
+	hardware.lock.RLock()
+	fresh := !state.status.LastSuccess.IsZero() && !state.status.LastSuccess.Before(hardware.lastCollect)
+	hardware.lock.RUnlock()
+	return fresh && hardware.Decode(name, value)
+}
+
+// Return the status of every collector, in registration order
+func (hardware *Hardware) Status() []CollectorStatus {
+	hardware.lock.RLock()
+	defer hardware.lock.RUnlock()
+
+	status := make([]CollectorStatus, 0, len(hardware.states))
+	for _, state := range hardware.states {
+		status = append(status, state.status)
+	}
+	return status
 }
 
 func (hardware *Hardware) String() string {
 	str := "\t\t\t---Hardware Information---\n"
 
-	str += hardware.SysInfo.String() + "\n"
-	str += hardware.DiskInfo.String() + "\n"
-	str += hardware.CpuInfo.String() + "\n"
-	str += hardware.ProcessInfo.String() + "\n"
-	str += hardware.NetInfo.String()
+	for _, state := range hardware.states {
+		if stringer, ok := state.collector.(fmt.Stringer); ok {
+			str += stringer.String() + "\n"
+		}
+	}
 
 	return str
 }
 
-func (hardware *Hardware) ToHtml(tmplPath string) (string, error) {
-	//Get the template
-	tmpl, err := template.New("tmpl.html").ParseFiles(tmplPath)
-	if err != nil {
-		return "", err
-	}
+// A rendered collector, the extra sections are the ones without a dedicated place in the main template
+type Section struct {
+	Name string
+	Html template.HTML
+	Rows []Row //Rows of the table, nil if the collector is not a TableCollector
+}
 
-	//Get all the template string
-	sysTmpl, err := hardware.SysInfo.ToHtml(SYSTEM_TMPL)
-	if err != nil {
-		return "", err
-	}
+// Data of the main template
+type layout struct {
+	Sections map[string]template.HTML
+	Extra    []Section
+	Status   []CollectorStatus
+	show     func(section string) bool
+}
 
-	diskTmpl, err := hardware.DiskInfo.ToHtml(DISK_TMPL)
-	if err != nil {
-		return "", err
+// Whether the section is part of the page
+func (data layout) Show(section string) bool {
+	return data.show(section)
+}
+
+/*
+ * Render the page layout with the sections accepted by show (nil for all of them): the collectors
+ * and STATUS_SECTION. Every section is wrapped in an element with the id "section-<name>",
+ * so it can then be updated alone with the fragments of Section and StatusHtml
+ */
+func (hardware *Hardware) ToHtml(show func(section string) bool) (string, error) {
+	if show == nil {
+		show = func(string) bool { return true }
 	}
 
-	cpuTmpl, err := hardware.CpuInfo.ToHtml(CPU_TMPL)
-	if err != nil {
-		return "", err
+	/*
+	 * Use the last successful render of every collector, so a failing collector keep showing its previous data.
+	 * Built-in collectors have their own place in the template and are looked up by name,
+	 * the others are appended at the end of the page in registration order.
+	 * We use template.HTML instead of string to prevent HTML escaping
+	 */
+	data := layout{
+		Sections: make(map[string]template.HTML),
+		Status:   hardware.Status(),
+		show:     show,
 	}
 
-	processesTmpl, err := hardware.ProcessInfo.ToHtml(PROCESS_TMPL)
-	if err != nil {
-		return "", err
+	hardware.lock.RLock()
+	for _, state := range hardware.states {
+		name := state.collector.Name()
+		switch name {
+		case SYSTEM_COLLECTOR, DISK_COLLECTOR, CPU_COLLECTOR, PROCESS_COLLECTOR, NET_COLLECTOR:
+			data.Sections[name] = state.html
+		default:
+			if show(name) {
+				data.Extra = append(data.Extra, Section{Name: name, Html: state.html})
+			}
+		}
 	}
+	hardware.lock.RUnlock()
 
-	netTmpl, err := hardware.NetInfo.ToHtml(NET_TMPL)
-	if err != nil {
-		return "", err
-	}
-
-	// Use template.HTML instead of string to prevent HTML escaping
-	data := struct {
-		SysTmpl       template.HTML
-		DiskTmpl      template.HTML
-		CpuTmpl       template.HTML
-		ProcessesTmpl template.HTML
-		NetTmpl       template.HTML
-	}{
-		SysTmpl:       template.HTML(sysTmpl),
-		DiskTmpl:      template.HTML(diskTmpl),
-		CpuTmpl:       template.HTML(cpuTmpl),
-		ProcessesTmpl: template.HTML(processesTmpl),
-		NetTmpl:       template.HTML(netTmpl),
-	}
-
-	//Execute template
-	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, data)
-	if err != nil {
-		return "", err
+	//Execute template, the status table is a template of its own included by the layout
+	html, err := ExecuteTemplate(TMPL, data)
+	return string(html), err
+}
+
+// Return the last successful render of a collector (and its rows), false if the collector does not exist
+func (hardware *Hardware) Section(name string) (Section, bool) {
+	hardware.lock.RLock()
+	defer hardware.lock.RUnlock()
+
+	for _, state := range hardware.states {
+		if state.collector.Name() == name {
+			return Section{Name: name, Html: state.html, Rows: state.rows}, true
+		}
 	}
-	return buffer.String(), nil
+	return Section{}, false
 }
 
+// Render the status table of the collectors (STATUS_SECTION)
+func (hardware *Hardware) StatusHtml() (template.HTML, error) {
+	return ExecuteTemplate(STATUS_TMPL, hardware.Status())
+}
+
+/*
+ * Run every collector concurrently. A collector that fails or exceed its timeout does not affect the others:
+ * its previous result is kept and the error is recorded in its status.
+ * The returned error join the errors of every failed collector (nil if all succeeded)
+ */
 func (hardware *Hardware) CollectData() error {
-	var err error
-	err = hardware.SysInfo.GetSystemInfo()
-	if err != nil {
-		return err
-	}
+	errs := make([]error, len(hardware.states))
+	now := time.Now()
+	hardware.lock.Lock()
+	hardware.lastCollect = now
+	hardware.lock.Unlock()
 
-	err = hardware.DiskInfo.GetDiskInfo()
-	if err != nil {
-		return err
+	var wg sync.WaitGroup
+	for i, state := range hardware.states {
+		//Collectors with their own interval are skipped until it is elapsed
+		if state.interval > 0 && now.Sub(state.lastRun) < state.interval {
+			continue
+		}
+		state.lastRun = now
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = hardware.collect(state)
+		}()
 	}
+	wg.Wait()
 
-	err = hardware.CpuInfo.GetCPUInfo(0)
-	if err != nil {
+	return errors.Join(errs...)
+}
+
+// Run one collector with its timeout
+func (hardware *Hardware) collect(state *collectorState) error {
+	name := state.collector.Name()
+
+	//If the previous collection is still hanging (ex: stale NFS mount), don't pile up another one
+	if !state.busy.TryLock() {
+		err := fmt.Errorf("collector %s: previous collection is still running", name)
+		hardware.record(state, time.Time{}, "", nil, nil, err)
 		return err
 	}
 
-	err = hardware.ProcessInfo.GetAllProcessInfo()
-	if err != nil {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer state.busy.Unlock()
+
+		//Collect, then render and serialize while we still own the collector
+		var (
+			html template.HTML
+			rows []Row
+			data []byte
+		)
+		err := state.collector.Collect()
+		if err == nil {
+			if table, ok := state.collector.(TableCollector); ok {
+				html, rows, err = table.RenderTable()
+			} else {
+				html, err = state.collector.Render()
+			}
+		}
+		if err == nil {
+			data, err = state.collector.Serialize()
+		}
+		if err != nil {
+			err = fmt.Errorf("collector %s: %w", name, err)
+		}
+
+		//Record the result even if we already timed out, a late success is still a success
+		hardware.record(state, start, html, rows, data, err)
+		done <- err
+	}()
+
+	timer := time.NewTimer(state.timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		err := fmt.Errorf("collector %s: timed out after %s", name, state.timeout)
+		hardware.record(state, start, "", nil, nil, err)
 		return err
 	}
+}
 
-	err = hardware.NetInfo.GetAllConnection()
+// Save the result of a collection (a zero start mean the collector did not run at all)
+func (hardware *Hardware) record(state *collectorState, start time.Time, html template.HTML, rows []Row, data []byte, err error) {
+	hardware.lock.Lock()
+	defer hardware.lock.Unlock()
+
+	now := time.Now()
+	if !start.IsZero() {
+		state.status.LastDurationMs = float64(now.Sub(start).Microseconds()) / 1000
+	}
+	name := state.collector.Name()
 	if err != nil {
-		return err
+		//Only log when the error change, a collector failing at every tick would flood the logs
+		if err.Error() != state.status.LastError {
+			slog.Warn("Collector failed", "collector", name, "duration_ms", state.status.LastDurationMs, "error", err)
+		} else {
+			slog.Debug("Collector still failing", "collector", name, "error", err)
+		}
+		state.status.Healthy = false
+		state.status.LastError = err.Error()
+		state.status.LastErrorAt = now
+		return
+	}
+	if state.status.LastError != "" {
+		slog.Info("Collector recovered", "collector", name, "duration_ms", state.status.LastDurationMs)
 	}
 
-	return nil
+	state.html = html
+	state.rows = rows
+	state.json = data
+	state.status.Healthy = true
+	state.status.LastError = ""
+	state.status.LastSuccess = now
 }
@@ -1,7 +1,7 @@
 package hardware
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
 
@@ -19,8 +19,8 @@ var SocketType map[uint32]string = map[uint32]string{
 }
 
 type Address struct {
-	IP   string
-	Port uint32
+	IP   string `json:"ip"`
+	Port uint32 `json:"port"`
 }
 
 func (add *Address) String() string {
@@ -28,12 +28,12 @@ func (add *Address) String() string {
 }
 
 type ConnectionInfo struct {
-	PID         int32   // Process PID that use the connection
-	ProcessName string  // The name of the process that used the connection
-	Type        uint32  // Socket type (SOCK_STREAM = TCP, SOCK_DGRAM = UDP)
-	LocalAddr   Address // Local address (IP and Port)
-	RemoteAddr  Address // Remote address (IP and Port)
-	Status      string  // Connection status (e.g., "ESTABLISHED", "LISTEN")
+	PID         int32   `json:"pid"`          // Process PID that use the connection
+	ProcessName string  `json:"process_name"` // The name of the process that used the connection
+	Type        uint32  `json:"socket_type"`  // Socket type (SOCK_STREAM = TCP, SOCK_DGRAM = UDP)
+	LocalAddr   Address `json:"local_addr"`   // Local address (IP and Port)
+	RemoteAddr  Address `json:"remote_addr"`  // Remote address (IP and Port)
+	Status      string  `json:"status"`       // Connection status (e.g., "ESTABLISHED", "LISTEN")
 }
 
 func (connInfo *ConnectionInfo) String() string {
@@ -60,27 +60,41 @@ func (connections Connections) String() string {
 	return str
 }
 
-func (connections *Connections) ToHtml(tmplPath string) (string, error) {
-	//Func map
-	funcMap := template.FuncMap{
-		"DisplayAddress": func(add Address) string {
-			return add.String()
-		},
-	}
+func (connections *Connections) ToHtml() (string, error) {
+	html, _, err := connections.RenderTable()
+	return string(html), err
+}
 
-	//Get the template
-	tmpl, err := template.New("netTmpl.html").Funcs(funcMap).ParseFiles(tmplPath)
-	if err != nil {
-		return "", err
-	}
+/*---Collector interface---*/
 
-	//Execute template
-	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, connections)
+func (connections *Connections) Name() string {
+	return NET_COLLECTOR
+}
+
+func (connections *Connections) Collect() error {
+	return connections.GetAllConnection()
+}
+
+func (connections *Connections) Render() (template.HTML, error) {
+	html, _, err := connections.RenderTable()
+	return html, err
+}
+
+// Render the table and its rows, a connection is identified by its process, type and addresses
+func (connections *Connections) RenderTable() (template.HTML, []Row, error) {
+	tmpl, err := Template(NET_TMPL)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	return buffer.String(), nil
+
+	return renderTable(tmpl, NET_COLLECTOR, *connections, func(connInfo ConnectionInfo) string {
+		return fmt.Sprintf("%d/%d/%s:%d/%s:%d", connInfo.PID, connInfo.Type,
+			connInfo.LocalAddr.IP, connInfo.LocalAddr.Port, connInfo.RemoteAddr.IP, connInfo.RemoteAddr.Port)
+	}, nil)
+}
+
+func (connections *Connections) Serialize() ([]byte, error) {
+	return json.Marshal(connections)
 }
 
 func (connections *Connections) GetAllConnection() error {
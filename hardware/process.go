@@ -1,20 +1,38 @@
 package hardware
 
 import (
-	"bytes"
+	"cmp"
+	"encoding/json"
 	"fmt"
 	"html/template"
-	"sort"
+	"os/user"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/shirou/gopsutil/process"
 )
 
 type ProcessInfo struct {
-	PID                int32   //Process ID
-	Name               string  //Process name
-	NumberOfThreadUsed int32   //Number of threads that process currently used
-	CpuUsagePercent    float64 //The CPU usage of that process
-	MemoryUsed         uint64  //The amount of memory the current process is holding in RAM (not including swap)
+	PID                int32   `json:"pid"`         //Process ID
+	PPID               int32   `json:"ppid"`        //Parent process ID
+	Name               string  `json:"name"`        //Process name
+	User               string  `json:"user"`        //Name of the user owning the process (its UID if it has no name)
+	NumberOfThreadUsed int32   `json:"threads"`     //Number of threads that process currently used
+	CpuUsagePercent    float64 `json:"cpu_percent"` //The CPU usage of that process since the previous collection (100% = one core, unless normalized)
+	CpuTime            float64 `json:"cpu_seconds"` //User and system CPU time used since the process started
+	MemoryUsed         uint64  `json:"rss_bytes"`   //The amount of memory the current process is holding in RAM (not including swap)
+
+	//Disk I/O from /proc/<pid>/io, only readable for our own processes unless running as root (zero otherwise)
+	ReadBytes        uint64  `json:"read_bytes"`             //Bytes fetched from storage since the process started
+	WriteBytes       uint64  `json:"write_bytes"`            //Bytes sent to storage since the process started
+	ReadSyscalls     uint64  `json:"read_syscalls"`          //Read syscalls since the process started (any file, socket or pipe)
+	WriteSyscalls    uint64  `json:"write_syscalls"`         //Write syscalls since the process started
+	ReadBytesRate    float64 `json:"read_bytes_per_sec"`     //Bytes read per second since the previous collection
+	WriteBytesRate   float64 `json:"write_bytes_per_sec"`    //Bytes written per second since the previous collection
+	ReadSyscallRate  float64 `json:"read_syscalls_per_sec"`  //Read syscalls per second since the previous collection
+	WriteSyscallRate float64 `json:"write_syscalls_per_sec"` //Write syscalls per second since the previous collection
 }
 
 func NewProcessInfo() *ProcessInfo {
@@ -23,10 +41,14 @@ func NewProcessInfo() *ProcessInfo {
 
 func (procInfo *ProcessInfo) String() string {
 	str := fmt.Sprintf("PID: %d\n", procInfo.PID)
+	str += fmt.Sprintf("Parent PID: %d\n", procInfo.PPID)
 	str += fmt.Sprintf("Process name: %s\n", procInfo.Name)
+	str += fmt.Sprintf("User: %s\n", procInfo.User)
 	str += fmt.Sprintf("Number of thread used: %d\n", procInfo.NumberOfThreadUsed)
 	str += fmt.Sprintf("CPU Usage: %.2f%%\n", procInfo.CpuUsagePercent)
-	str += fmt.Sprintf("Memory used: %s", ConvertByte(procInfo.MemoryUsed))
+	str += fmt.Sprintf("Memory used: %s\n", ConvertByte(procInfo.MemoryUsed))
+	str += fmt.Sprintf("Disk read: %s (%.0f syscalls/s)\n", ConvertRate(procInfo.ReadBytesRate), procInfo.ReadSyscallRate)
+	str += fmt.Sprintf("Disk write: %s (%.0f syscalls/s)", ConvertRate(procInfo.WriteBytesRate), procInfo.WriteSyscallRate)
 
 	return str
 }
@@ -37,23 +59,41 @@ func (procInfo *ProcessInfo) GetProcessInfo(runningProc *process.Process) error
 	//Get process PID
 	procInfo.PID = runningProc.Pid
 
+	//Get the parent PID, to build the process tree
+	procInfo.PPID, err = runningProc.Ppid()
+	if err != nil {
+		return err
+	}
+
 	//Get process's name
 	procInfo.Name, err = runningProc.Name()
 	if err != nil {
 		return err
 	}
 
+	//Get the owner of the process
+	uids, err := runningProc.Uids()
+	if err != nil {
+		return err
+	}
+	procInfo.User = ""
+	if len(uids) > 0 {
+		procInfo.User = userName(uids[0])
+	}
+
 	//Get the number of threads used by a process
 	procInfo.NumberOfThreadUsed, err = runningProc.NumThreads()
 	if err != nil {
 		return err
 	}
 
-	//Get the cpu usage (%) of that process
-	procInfo.CpuUsagePercent, err = runningProc.CPUPercent()
+	//Get the CPU time of that process, the usage is computed from its increase between two collections
+	//(process.CPUPercent return the average usage since the process started)
+	times, err := runningProc.Times()
 	if err != nil {
 		return err
 	}
+	procInfo.CpuTime = times.User + times.System
 
 	//Get the memory information of that process
 	memoryStat, err := runningProc.MemoryInfo()
@@ -68,9 +108,86 @@ func (procInfo *ProcessInfo) GetProcessInfo(runningProc *process.Process) error
 	 */
 	procInfo.MemoryUsed = memoryStat.RSS //Get the current memory that process is taken in RAM
 
+	//Get the disk I/O counters, a process we are not allowed to inspect is still listed without them
+	ioStat, ioErr := runningProc.IOCounters()
+	if ioErr != nil {
+		ioStat = &process.IOCountersStat{}
+	}
+	procInfo.ReadBytes, procInfo.WriteBytes = ioStat.ReadBytes, ioStat.WriteBytes
+	procInfo.ReadSyscalls, procInfo.WriteSyscalls = ioStat.ReadCount, ioStat.WriteCount
+
 	return err
 }
 
+// Names of the users by UID, they are looked up once
+var userNames = struct {
+	sync.Mutex
+	byUID map[int32]string
+}{byUID: make(map[int32]string)}
+
+func userName(uid int32) string {
+	userNames.Lock()
+	defer userNames.Unlock()
+
+	name, ok := userNames.byUID[uid]
+	if !ok {
+		name = strconv.Itoa(int(uid))
+		if owner, err := user.LookupId(name); err == nil {
+			name = owner.Username
+		}
+		userNames.byUID[uid] = name
+	}
+	return name
+}
+
+// Counters of a process at a collection, the rates are computed from the difference with the next one
+type processSample struct {
+	createTime int64                   //Start time of the process, a different one means the PID has been reused
+	time       time.Time               //When the counters were read
+	cpuTime    float64                 //User and system CPU time, in seconds
+	io         *process.IOCountersStat //Disk I/O counters, nil if they are unreadable (or all zero, which we can't tell apart)
+}
+
+/*
+ * Set the CPU usage and the I/O rates from the sample of the previous collection. Without one (the process is new),
+ * the CPU usage is its average since it started if that is after since (the previous collection), so the average
+ * covers the same period as for the other processes, and 0 otherwise. The I/O rates are zero.
+ * cores is the number of cores the CPU usage is divided by
+ */
+func (procInfo *ProcessInfo) setRates(previous *processSample, current processSample, since time.Time, cores float64) {
+	procInfo.CpuUsagePercent = 0
+	procInfo.ReadBytesRate, procInfo.WriteBytesRate = 0, 0
+	procInfo.ReadSyscallRate, procInfo.WriteSyscallRate = 0, 0
+	if previous == nil {
+		started := time.UnixMilli(current.createTime)
+		if lifetime := current.time.Sub(started).Seconds(); !since.IsZero() && started.After(since) && lifetime > 0 {
+			procInfo.CpuUsagePercent = max(0, current.cpuTime/lifetime*100/cores)
+		}
+		return
+	}
+	elapsed := current.time.Sub(previous.time).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	procInfo.CpuUsagePercent = max(0, (current.cpuTime-previous.cpuTime)/elapsed*100/cores)
+
+	//Unreadable counters on either side are not a baseline, reporting their difference would be a huge spike
+	if previous.io == nil || current.io == nil {
+		return
+	}
+	rate := func(current, previous uint64) float64 {
+		if current < previous {
+			//The counters went backward, nothing meaningful to report
+			return 0
+		}
+		return float64(current-previous) / elapsed
+	}
+	procInfo.ReadBytesRate = rate(current.io.ReadBytes, previous.io.ReadBytes)
+	procInfo.WriteBytesRate = rate(current.io.WriteBytes, previous.io.WriteBytes)
+	procInfo.ReadSyscallRate = rate(current.io.ReadCount, previous.io.ReadCount)
+	procInfo.WriteSyscallRate = rate(current.io.WriteCount, previous.io.WriteCount)
+}
+
 type Processes []ProcessInfo
 
 func NewProcesses() *Processes {
@@ -85,53 +202,143 @@ func (processes *Processes) String() string {
 	return str
 }
 
-func (processes Processes) Len() int {
-	return len(processes)
+func (processes *Processes) ToHtml() (string, error) {
+	html, _, err := processes.RenderTable()
+	return string(html), err
 }
 
-func (processes Processes) Less(i, j int) bool {
-	/*
-	 * A process is evaluated by its threads used, cpu usage and memory usage
-	 * Evaluation: 20% * Threads used + 40% * CPU Usage + 40% * Memory Usage
-	 * The sort is descending
-	 */
-	proc1, proc2 := processes[i], processes[j]
-	stat1 := float64(proc1.NumberOfThreadUsed)*0.2 + proc1.CpuUsagePercent*0.4 + float64(proc1.MemoryUsed)*0.4
-	stat2 := float64(proc2.NumberOfThreadUsed)*0.2 + proc2.CpuUsagePercent*0.4 + float64(proc2.MemoryUsed)*0.4
+/*---Collector interface---*/
+
+func (processes *Processes) Name() string {
+	return PROCESS_COLLECTOR
+}
 
-	return stat1 > stat2
+func (processes *Processes) Render() (template.HTML, error) {
+	html, _, err := processes.RenderTable()
+	return html, err
 }
 
-func (processes Processes) Swap(i, j int) {
-	processes[i], processes[j] = processes[j], processes[i]
+// Row of the process table, the processes are displayed as a tree
+type processRow struct {
+	ProcessNode
+	Depth int `json:"depth"` //Number of ancestors in the tree
 }
 
-func (processes *Processes) ToHtml(tmplPath string) (string, error) {
-	//Func map
-	funcMap := template.FuncMap{
-		"ConvertByte": ConvertByte,
+// Don't send the children with every row, they have their own rows
+func (row processRow) MarshalJSON() ([]byte, error) {
+	node := row.ProcessNode
+	node.Children = nil
+	return json.Marshal(struct {
+		ProcessNode
+		Children []*ProcessNode `json:"children,omitempty"`
+		Depth    int            `json:"depth"`
+	}{ProcessNode: node, Depth: row.Depth})
+}
+
+/*
+ * Render the default view (the tree) and its rows, a process is identified by its PID.
+ * The rows are in tree order: every process is followed by its children
+ */
+func (processes *Processes) RenderTable() (template.HTML, []Row, error) {
+	tmpl, err := Template(PROCESS_TMPL)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var rows []processRow
+	WalkTree(processes.Tree(), func(node *ProcessNode, depth int) {
+		rows = append(rows, processRow{ProcessNode: *node, Depth: depth})
+	})
+
+	return renderTable(tmpl, PROCESS_COLLECTOR, rows, func(row processRow) string {
+		return strconv.Itoa(int(row.PID))
+	}, func(row processRow) string {
+		if row.Depth == 0 {
+			return ""
+		}
+		return strconv.Itoa(int(row.PPID))
+	})
+}
+
+// A view of the processes rendered for a query
+type ProcessTable struct {
+	Html    template.HTML
+	Rows    []Row  //In display order
+	Total   int    //Number of processes matching the filters, before the pagination
+	Summary string //Description of the rows shown
+}
+
+/*
+ * Render the processes selected by a validated query, as a flat list in the order of the query.
+ * The zero query render the default view
+ */
+func (processes Processes) RenderQuery(query ProcessQuery) (ProcessTable, error) {
+	if query.IsZero() {
+		html, rows, err := processes.RenderTable()
+		return ProcessTable{Html: html, Rows: rows, Total: len(processes)}, err
 	}
 
-	//Get the template
-	tmpl, err := template.New("processTmpl.html").Funcs(funcMap).ParseFiles(tmplPath)
+	tmpl, err := Template(PROCESS_TMPL)
 	if err != nil {
-		return "", err
+		return ProcessTable{}, err
 	}
 
-	//Execute template
-	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, processes)
+	page, total := processes.Apply(query)
+	rows := make([]processRow, 0, len(page))
+	for _, procInfo := range page {
+		rows = append(rows, processRow{ProcessNode: ProcessNode{ProcessInfo: procInfo}})
+	}
+	tableRows, err := renderRows(tmpl, PROCESS_COLLECTOR, rows, func(row processRow) string {
+		return strconv.Itoa(int(row.PID))
+	}, nil)
 	if err != nil {
-		return "", err
+		return ProcessTable{}, err
+	}
+
+	order := "descending"
+	if !query.Descending() {
+		order = "ascending"
+	}
+	summary := fmt.Sprintf("%d processes, sorted by %s (%s)", total, sortLabel(cmp.Or(query.Sort, SORT_CPU)), order)
+	if len(page) < total {
+		summary = fmt.Sprintf("%d-%d of %s", query.Offset+1, query.Offset+len(page), summary)
+	}
+	if len(page) == 0 {
+		summary = fmt.Sprintf("No process to show (%d matching the filters)", total)
 	}
-	return buffer.String(), nil
+
+	html, err := executeTable(tmpl, PROCESS_COLLECTOR, tableRows, summary)
+	return ProcessTable{Html: html, Rows: tableRows, Total: total, Summary: summary}, err
+}
+
+func (processes *Processes) Serialize() ([]byte, error) {
+	return json.Marshal(processes)
+}
+
+/*
+ * Collector of the processes. Processes is a plain slice (it is also the JSON of the API), the collector keeps
+ * the counters of the previous collection to compute the CPU usage and the I/O rates between two collections
+ */
+type ProcessCollector struct {
+	Processes
+	NormalizeCPU bool //Divide the CPU usage by the number of cores, so 100% is the whole machine instead of one core (as top does)
+
+	samples     map[int32]processSample //Samples of the previous collection by PID
+	lastCollect time.Time               //When the previous collection started, zero before the first one
+}
+
+func NewProcessCollector() *ProcessCollector {
+	return &ProcessCollector{samples: make(map[int32]processSample)}
 }
 
-func (processes *Processes) GetAllProcessInfo() error {
+func (collector *ProcessCollector) Collect() error {
+	processes := &collector.Processes
+
 	//Clean the processes to avoid duplicate
 	*processes = (*processes)[:0]
 
 	//Get all running processes
+	start := time.Now()
 	runningProcesses, err := process.Processes()
 	if err != nil {
 		return err
@@ -139,6 +346,13 @@ func (processes *Processes) GetAllProcessInfo() error {
 
 	procInfo := NewProcessInfo()
 
+	//The rates cover the time since the previous collection, the samples of the exited processes are dropped
+	samples := make(map[int32]processSample, len(runningProcesses))
+	cores := 1.0
+	if collector.NormalizeCPU {
+		cores = float64(runtime.NumCPU())
+	}
+
 	for _, runningProc := range runningProcesses {
 		//If we find some process with PID = 0, ignore them (PID = 0 usually idle, which is not what we want to track)
 		if runningProc.Pid > 0 {
@@ -147,12 +361,34 @@ func (processes *Processes) GetAllProcessInfo() error {
 			if err != nil {
 				continue
 			}
+
+			createTime, _ := runningProc.CreateTime()
+			sample := processSample{createTime: createTime, time: time.Now(), cpuTime: procInfo.CpuTime}
+			if procInfo.ReadBytes > 0 || procInfo.WriteBytes > 0 || procInfo.ReadSyscalls > 0 || procInfo.WriteSyscalls > 0 {
+				sample.io = &process.IOCountersStat{
+					ReadCount:  procInfo.ReadSyscalls,
+					WriteCount: procInfo.WriteSyscalls,
+					ReadBytes:  procInfo.ReadBytes,
+					WriteBytes: procInfo.WriteBytes,
+				}
+			}
+			//Without a start time we can't tell a reused PID apart, so there is no baseline
+			var previous *processSample
+			if prev, ok := collector.samples[procInfo.PID]; ok && createTime != 0 && prev.createTime == createTime {
+				previous = &prev
+			}
+			procInfo.setRates(previous, sample, collector.lastCollect, cores)
+			samples[procInfo.PID] = sample
+
 			*processes = append(*processes, *procInfo)
 		}
 	}
+	collector.samples = samples
+	collector.lastCollect = start
 
-	//Sort the processes based on threads used, CPU usage and memory usage
-	sort.Sort(processes)
+	//The heaviest CPU users first, the order of the API and of the per-process metrics
+	SortProcesses(*processes, SORT_CPU, true)
 
-	return err
+	//Errors of individual processes (usually exited while we were reading them) are not a collector failure
+	return nil
 }
@@ -0,0 +1,179 @@
+package hardware
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Keys the processes can be sorted by
+const (
+	SORT_CPU     = "cpu"
+	SORT_MEMORY  = "memory"
+	SORT_THREADS = "threads"
+	SORT_PID     = "pid"
+	SORT_NAME    = "name"
+	SORT_IO      = "io"    //Bytes read and written per second
+	SORT_READ    = "read"  //Bytes read per second
+	SORT_WRITE   = "write" //Bytes written per second
+)
+
+type SortKey struct {
+	Key   string
+	Label string //Shown on the dashboard
+}
+
+// Sort keys in the order they are offered
+var SortKeys = []SortKey{
+	{SORT_CPU, "CPU usage"},
+	{SORT_MEMORY, "memory used"},
+	{SORT_THREADS, "threads used"},
+	{SORT_IO, "disk I/O"},
+	{SORT_READ, "disk read"},
+	{SORT_WRITE, "disk write"},
+	{SORT_PID, "PID"},
+	{SORT_NAME, "name"},
+}
+
+const (
+	ORDER_ASC  = "asc"
+	ORDER_DESC = "desc"
+)
+
+/*
+ * Which processes to show and in which order. The zero query is the default view: every process,
+ * displayed as a tree on the dashboard. Any other query display a flat list
+ */
+type ProcessQuery struct {
+	Sort   string `json:"sort"`   //Sort key (SORT_*), SORT_CPU if empty
+	Order  string `json:"order"`  //ORDER_ASC or ORDER_DESC, empty for the natural order of the key (descending for the usages)
+	Name   string `json:"name"`   //Only the processes whose name contain it, case insensitive
+	User   string `json:"user"`   //Only the processes of this user
+	Regex  string `json:"regex"`  //Only the processes whose name match this regular expression
+	Offset int    `json:"offset"` //Number of processes skipped, for the pagination
+	Limit  int    `json:"limit"`  //Maximum number of processes, 0 for all of them
+
+	regex *regexp.Regexp //Compiled by Validate
+}
+
+// Check the query and compile its regular expression, it must be called before Apply
+func (query *ProcessQuery) Validate() error {
+	if query.Sort != "" && !slices.ContainsFunc(SortKeys, func(key SortKey) bool { return key.Key == query.Sort }) {
+		return fmt.Errorf("unknown sort key %q", query.Sort)
+	}
+	if query.Order != "" && query.Order != ORDER_ASC && query.Order != ORDER_DESC {
+		return fmt.Errorf("invalid order %q (asc or desc)", query.Order)
+	}
+	if query.Offset < 0 || query.Limit < 0 {
+		return fmt.Errorf("offset and limit must be positive")
+	}
+
+	query.regex = nil
+	if query.Regex != "" {
+		regex, err := regexp.Compile(query.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		query.regex = regex
+	}
+	return nil
+}
+
+func (query ProcessQuery) IsZero() bool {
+	return query.Key() == ProcessQuery{}
+}
+
+// The query without its compiled regex, comparable between clients asking for the same view
+func (query ProcessQuery) Key() ProcessQuery {
+	query.regex = nil
+	return query
+}
+
+// Whether the processes are sorted in descending order
+func (query ProcessQuery) Descending() bool {
+	if query.Order != "" {
+		return query.Order == ORDER_DESC
+	}
+	return query.Sort != SORT_PID && query.Sort != SORT_NAME
+}
+
+func (query ProcessQuery) match(procInfo ProcessInfo) bool {
+	if query.Name != "" && !strings.Contains(strings.ToLower(procInfo.Name), strings.ToLower(query.Name)) {
+		return false
+	}
+	if query.User != "" && procInfo.User != query.User {
+		return false
+	}
+	return query.regex == nil || query.regex.MatchString(procInfo.Name)
+}
+
+/*
+ * Filter, sort then paginate the processes. Return the page and the number of processes
+ * matching the filters. The processes are not modified
+ */
+func (processes Processes) Apply(query ProcessQuery) (Processes, int) {
+	matching := make(Processes, 0, len(processes))
+	for _, procInfo := range processes {
+		if query.match(procInfo) {
+			matching = append(matching, procInfo)
+		}
+	}
+	SortProcesses(matching, cmp.Or(query.Sort, SORT_CPU), query.Descending())
+
+	total := len(matching)
+	start := min(query.Offset, total)
+	end := total
+	if query.Limit > 0 {
+		end = min(start+query.Limit, total)
+	}
+	return matching[start:end], total
+}
+
+// Sort the processes by a key (SORT_*), the ties are broken by PID
+func SortProcesses(processes Processes, key string, descending bool) {
+	value := sortValue(key)
+	slices.SortStableFunc(processes, func(a, b ProcessInfo) int {
+		var order int
+		if key == SORT_NAME {
+			order = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		} else {
+			order = cmp.Compare(value(a), value(b))
+		}
+		if descending {
+			order = -order
+		}
+		return cmp.Or(order, cmp.Compare(a.PID, b.PID))
+	})
+}
+
+// Numeric value of a process for a sort key
+func sortValue(key string) func(procInfo ProcessInfo) float64 {
+	switch key {
+	case SORT_MEMORY:
+		return func(procInfo ProcessInfo) float64 { return float64(procInfo.MemoryUsed) }
+	case SORT_THREADS:
+		return func(procInfo ProcessInfo) float64 { return float64(procInfo.NumberOfThreadUsed) }
+	case SORT_PID:
+		return func(procInfo ProcessInfo) float64 { return float64(procInfo.PID) }
+	case SORT_IO:
+		return func(procInfo ProcessInfo) float64 { return procInfo.ReadBytesRate + procInfo.WriteBytesRate }
+	case SORT_READ:
+		return func(procInfo ProcessInfo) float64 { return procInfo.ReadBytesRate }
+	case SORT_WRITE:
+		return func(procInfo ProcessInfo) float64 { return procInfo.WriteBytesRate }
+	default:
+		return func(procInfo ProcessInfo) float64 { return procInfo.CpuUsagePercent }
+	}
+}
+
+// Label of a sort key on the dashboard
+func sortLabel(key string) string {
+	for _, sortKey := range SortKeys {
+		if sortKey.Key == key {
+			return sortKey.Label
+		}
+	}
+	return key
+}
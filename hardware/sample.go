@@ -0,0 +1,76 @@
+package hardware
+
+import "fmt"
+
+/*
+ * A Sample is a single named value extracted from the built-in collectors, used to keep track of the
+ * values over time. Names are dot separated, values which exist once per device/core/process have the
+ * key appended after a colon:
+ *
+ *	cpu.usage, cpu.core:0, load.1, load.5, load.15, memory.used, memory.total, memory.used_percent,
+ *	disk.free:/home, disk.total:/home, disk.free_percent:/home (by mountpoint, a device can be mounted several times),
+ *	process.cpu:1234, process.rss:1234, process.threads:1234
+ */
+type Sample struct {
+	Name  string
+	Value float64
+}
+
+/*
+ * Return the samples of the built-in collectors that succeeded during the last call to CollectData.
+ * A failing collector, or one waiting for its interval, has no sample: the history show a gap instead of its old values.
+ * Only the maxProcesses processes using the most CPU are turned into samples
+ */
+func (hardware *Hardware) Samples(maxProcesses int) []Sample {
+	var samples []Sample
+	add := func(name string, value float64) {
+		samples = append(samples, Sample{Name: name, Value: value})
+	}
+
+	var cpuInfo CpuInfo
+	if hardware.DecodeFresh(CPU_COLLECTOR, &cpuInfo) {
+		add("cpu.usage", cpuInfo.TotalUsage)
+		for core, usage := range cpuInfo.UsagePerCores {
+			add(fmt.Sprintf("cpu.core:%d", core), usage)
+		}
+		add("load.1", cpuInfo.Load1)
+		add("load.5", cpuInfo.Load5)
+		add("load.15", cpuInfo.Load15)
+	}
+
+	var sysInfo SystemInfo
+	if hardware.DecodeFresh(SYSTEM_COLLECTOR, &sysInfo) {
+		add("memory.used", float64(sysInfo.UsedVM))
+		add("memory.total", float64(sysInfo.TotalVM))
+		if sysInfo.TotalVM > 0 {
+			add("memory.used_percent", float64(sysInfo.UsedVM)/float64(sysInfo.TotalVM)*100)
+		}
+	}
+
+	var diskInfo DiskInfo
+	if hardware.DecodeFresh(DISK_COLLECTOR, &diskInfo) {
+		for _, partition := range diskInfo {
+			add("disk.free:"+partition.Mountpoint, float64(partition.Free))
+			add("disk.total:"+partition.Mountpoint, float64(partition.Total))
+			if partition.Total > 0 {
+				add("disk.free_percent:"+partition.Mountpoint, float64(partition.Free)/float64(partition.Total)*100)
+			}
+		}
+	}
+
+	var processes Processes
+	if hardware.DecodeFresh(PROCESS_COLLECTOR, &processes) {
+		if len(processes) > maxProcesses {
+			processes = processes[:maxProcesses]
+		}
+		for _, proc := range processes {
+			add(fmt.Sprintf("process.cpu:%d", proc.PID), proc.CpuUsagePercent)
+			add(fmt.Sprintf("process.rss:%d", proc.PID), float64(proc.MemoryUsed))
+			add(fmt.Sprintf("process.threads:%d", proc.PID), float64(proc.NumberOfThreadUsed))
+			add(fmt.Sprintf("process.read_rate:%d", proc.PID), proc.ReadBytesRate)
+			add(fmt.Sprintf("process.write_rate:%d", proc.PID), proc.WriteBytesRate)
+		}
+	}
+
+	return samples
+}
@@ -1,7 +1,7 @@
 package hardware
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
 
@@ -11,13 +11,13 @@ import (
 
 // System information
 type SystemInfo struct {
-	Hostname        string //Device hostname
-	TotalVM         uint64 //Total RAM
-	UsedVM          uint64 //Currently used RAM
-	RuntimeOS       string //Current OS (ex: linux, windows,...)
-	Platform        string //Current platform (ex: ubuntu, linuxmint,..)
-	PlatformFamily  string //Current family (ex: debian, rhel,...)
-	PlatformVersion string //Current version (ex: ubuntu 24.04,...)
+	Hostname        string `json:"hostname"`         //Device hostname
+	TotalVM         uint64 `json:"total_vm_bytes"`   //Total RAM
+	UsedVM          uint64 `json:"used_vm_bytes"`    //Currently used RAM
+	RuntimeOS       string `json:"os"`               //Current OS (ex: linux, windows,...)
+	Platform        string `json:"platform"`         //Current platform (ex: ubuntu, linuxmint,..)
+	PlatformFamily  string `json:"platform_family"`  //Current family (ex: debian, rhel,...)
+	PlatformVersion string `json:"platform_version"` //Current version (ex: ubuntu 24.04,...)
 }
 
 // Factory method: return a pointer to a new SystemInfo struct
@@ -40,25 +40,28 @@ func (sysInfo *SystemInfo) String() string {
 }
 
 // Return the HTML representation of systemInfo
-func (sysInfo *SystemInfo) ToHtml(tmplPath string) (string, error) {
-	//Func map
-	funcMap := template.FuncMap{
-		"ConvertByte": ConvertByte,
-	}
+func (sysInfo *SystemInfo) ToHtml() (string, error) {
+	html, err := ExecuteTemplate(SYSTEM_TMPL, sysInfo)
+	return string(html), err
+}
 
-	//Get the template
-	tmpl, err := template.New("systemTmpl.html").Funcs(funcMap).ParseFiles(tmplPath)
-	if err != nil {
-		return "", err
-	}
+/*---Collector interface---*/
 
-	//Execute template
-	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, sysInfo)
-	if err != nil {
-		return "", err
-	}
-	return buffer.String(), nil
+func (sysInfo *SystemInfo) Name() string {
+	return SYSTEM_COLLECTOR
+}
+
+func (sysInfo *SystemInfo) Collect() error {
+	return sysInfo.GetSystemInfo()
+}
+
+func (sysInfo *SystemInfo) Render() (template.HTML, error) {
+	html, err := sysInfo.ToHtml()
+	return template.HTML(html), err
+}
+
+func (sysInfo *SystemInfo) Serialize() ([]byte, error) {
+	return json.Marshal(sysInfo)
 }
 
 // Get the current system information
@@ -0,0 +1,158 @@
+package hardware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+)
+
+// A row of a table collector, identified by a key that is stable across collections (ex: the PID)
+type Row struct {
+	Key   string        //Unique inside the table
+	ID    string        //Id of the <tr> element in the page
+	Cells template.HTML //Rendered cells (<td>) of the row
+	Above string        //Id of the row a new row is inserted after (ex: its parent process), empty to append it
+	Json  []byte        //JSON representation of the row
+}
+
+/*
+ * Collectors whose data is a table (processes, connections). Instead of the whole table,
+ * a client that already has the previous rows can receive only the added, removed and changed ones.
+ * The rows are in a <tbody> with the id RowsID(name), every row is a <tr> with the id Row.ID
+ */
+type TableCollector interface {
+	Collector
+	RenderTable() (template.HTML, []Row, error) //Same as Render, with the rows in display order
+}
+
+// Id of the <tbody> holding the rows of a table collector
+func RowsID(name string) string {
+	return name + "-rows"
+}
+
+// Id of the element describing the rows shown (ex: the page of a sorted view), updated with the rows
+func SummaryID(name string) string {
+	return name + "-summary"
+}
+
+// Data of a table template
+type table struct {
+	Body      string //Id of the <tbody>
+	Rows      []Row
+	SummaryID string
+	Summary   string //Empty when all the rows are shown in their natural order
+}
+
+/*
+ * Render a table template: the "cells" template is executed for every item, then the template itself
+ * with a table holding the rows. Duplicated keys get a suffix so every row stay addressable.
+ * above return the key of the row an item is displayed under (nil if the rows are independent)
+ */
+func renderTable[T any](tmpl *template.Template, name string, items []T, key, above func(item T) string) (template.HTML, []Row, error) {
+	rows, err := renderRows(tmpl, name, items, key, above)
+	if err != nil {
+		return "", nil, err
+	}
+	html, err := executeTable(tmpl, name, rows, "")
+	return html, rows, err
+}
+
+// Render the rows of a table template, see renderTable
+func renderRows[T any](tmpl *template.Template, name string, items []T, key, above func(item T) string) ([]Row, error) {
+	rows := make([]Row, 0, len(items))
+	seen := make(map[string]int, len(items))
+	for _, item := range items {
+		rowKey := key(item)
+		if count := seen[rowKey]; count > 0 {
+			seen[rowKey]++
+			rowKey = fmt.Sprintf("%s#%d", rowKey, count)
+		} else {
+			seen[rowKey] = 1
+		}
+
+		var cells bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&cells, "cells", item); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		row := Row{Key: rowKey, ID: rowID(name, rowKey), Cells: template.HTML(cells.String()), Json: data}
+		if above != nil {
+			if aboveKey := above(item); aboveKey != "" {
+				row.Above = rowID(name, aboveKey)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Execute a table template with rendered rows
+func executeTable(tmpl *template.Template, name string, rows []Row, summary string) (template.HTML, error) {
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, table{Body: RowsID(name), Rows: rows, SummaryID: SummaryID(name), Summary: summary}); err != nil {
+		return "", err
+	}
+	return template.HTML(buffer.String()), nil
+}
+
+// Id of a row, the key is hashed when it is not usable as is in a CSS selector (ex: an address)
+func rowID(name, key string) string {
+	for _, char := range key {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '-' || char == '_') {
+			hash := fnv.New64a()
+			hash.Write([]byte(key))
+			return fmt.Sprintf("%s-%x", name, hash.Sum64())
+		}
+	}
+	return name + "-" + key
+}
+
+// Rows added, changed and removed between two versions of a table
+type TableDiff struct {
+	Added   []Row //In display order
+	Changed []Row //In display order
+	Removed []Row //Previous version of the rows
+}
+
+func (diff TableDiff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Changed) == 0 && len(diff.Removed) == 0
+}
+
+/*
+ * Compare the previous rows (by key) with the current ones. same tell whether two versions of a row are
+ * identical, so the HTML and JSON views can ignore the differences that do not concern them
+ */
+func DiffRows(previous map[string]Row, current []Row, same func(old, new Row) bool) TableDiff {
+	var diff TableDiff
+	keys := make(map[string]bool, len(current))
+	for _, row := range current {
+		keys[row.Key] = true
+		old, exist := previous[row.Key]
+		switch {
+		case !exist:
+			diff.Added = append(diff.Added, row)
+		case !same(old, row):
+			diff.Changed = append(diff.Changed, row)
+		}
+	}
+	for key, row := range previous {
+		if !keys[key] {
+			diff.Removed = append(diff.Removed, row)
+		}
+	}
+	return diff
+}
+
+// Index rows by key, to diff them with the next version of the table
+func IndexRows(rows []Row) map[string]Row {
+	index := make(map[string]Row, len(rows))
+	for _, row := range rows {
+		index[row.Key] = row
+	}
+	return index
+}
@@ -0,0 +1,207 @@
+package hardware
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"sync"
+	"sys/templates"
+	"time"
+)
+
+// Template file names
+const (
+	SYSTEM_TMPL  = "systemTmpl.html"
+	DISK_TMPL    = "diskTmpl.html"
+	CPU_TMPL     = "cpuTmpl.html"
+	PROCESS_TMPL = "processTmpl.html"
+	NET_TMPL     = "netTmpl.html"
+	STATUS_TMPL  = "statusTmpl.html"
+	TMPL         = "tmpl.html"
+)
+
+// Functions available in every template
+var templateFuncs = template.FuncMap{
+	"ConvertByte": ConvertByte,
+	"ConvertRate": ConvertRate,
+	"FormatTime":  FormatTime,
+	"DisplayAddress": func(add Address) string {
+		return add.String()
+	},
+}
+
+// Templates used by other files, parsed with them ({{ template "statusTmpl.html" }})
+var templateIncludes = map[string][]string{
+	TMPL: {STATUS_TMPL},
+}
+
+/*
+ * Every *.html file of the templates file system, parsed once. Each file is a template set of its own named
+ * after the file, so several files can define a template with the same name (ex: "cells").
+ * A reload parse everything again and only replace the templates if every file is valid
+ */
+type templateSet struct {
+	sync.RWMutex
+	files     fs.FS
+	templates map[string]*template.Template //File name -> parsed file
+	stamp     string                        //Names, sizes and modification times of the files, to detect changes
+}
+
+var loaded struct {
+	sync.Mutex
+	set *templateSet
+}
+
+// Parse every template of the file system, they are then used by all the collectors
+func LoadTemplates(files fs.FS) error {
+	set := &templateSet{files: files}
+	if err := set.reload(); err != nil {
+		return err
+	}
+
+	loaded.Lock()
+	defer loaded.Unlock()
+	loaded.set = set
+	return nil
+}
+
+// The loaded templates, the embedded ones are loaded on first use
+func loadedTemplates() (*templateSet, error) {
+	loaded.Lock()
+	defer loaded.Unlock()
+
+	if loaded.set == nil {
+		set := &templateSet{files: templates.Files}
+		if err := set.reload(); err != nil {
+			return nil, err
+		}
+		loaded.set = set
+	}
+	return loaded.set, nil
+}
+
+// Return the parsed template of a file
+func Template(name string) (*template.Template, error) {
+	set, err := loadedTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	set.RLock()
+	defer set.RUnlock()
+	tmpl, ok := set.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s not found", name)
+	}
+	return tmpl, nil
+}
+
+// Execute the template of a file
+func ExecuteTemplate(name string, data any) (template.HTML, error) {
+	tmpl, err := Template(name)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+	return template.HTML(buffer.String()), nil
+}
+
+/*
+ * Check the templates every interval and reload them when a file changed, until done is closed.
+ * Meant for development: an invalid template is logged and the previous version is kept
+ */
+func WatchTemplates(interval time.Duration, done <-chan struct{}) {
+	set, err := loadedTemplates()
+	if err != nil {
+		slog.Error("Failed to watch templates", "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		stamp, err := set.fingerprint()
+		if err != nil {
+			slog.Warn("Failed to check templates", "error", err)
+			continue
+		}
+		set.RLock()
+		changed := stamp != set.stamp
+		set.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := set.reload(); err != nil {
+			slog.Error("Failed to reload templates", "error", err)
+			//Don't retry until the files change again
+			set.Lock()
+			set.stamp = stamp
+			set.Unlock()
+			continue
+		}
+		slog.Info("Templates reloaded")
+	}
+}
+
+// Parse every file again, the templates are only replaced if all of them are valid
+func (set *templateSet) reload() error {
+	stamp, err := set.fingerprint()
+	if err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(set.files, "*.html")
+	if err != nil {
+		return err
+	}
+	parsed := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		tmpl, err := template.New(name).Funcs(templateFuncs).ParseFS(set.files, append([]string{name}, templateIncludes[name]...)...)
+		if err != nil {
+			return err
+		}
+		parsed[name] = tmpl
+	}
+
+	set.Lock()
+	defer set.Unlock()
+	set.templates = parsed
+	set.stamp = stamp
+	return nil
+}
+
+func (set *templateSet) fingerprint() (string, error) {
+	entries, err := fs.ReadDir(set.files, ".")
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var stamp bytes.Buffer
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".html" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&stamp, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp.String(), nil
+}
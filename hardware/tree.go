@@ -0,0 +1,167 @@
+package hardware
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// A process with its children, and the usage of its whole subtree (the process and all its descendants)
+type ProcessNode struct {
+	ProcessInfo
+	TreeCpuPercent float64        `json:"tree_cpu_percent"` //CPU usage of the subtree
+	TreeMemoryUsed uint64         `json:"tree_rss_bytes"`   //Memory used by the subtree
+	TreeThreads    int32          `json:"tree_threads"`     //Threads used by the subtree
+	Descendants    int            `json:"descendants"`      //Number of processes in the subtree, without the process itself
+	Children       []*ProcessNode `json:"children"`
+}
+
+/*
+ * Build the process tree from the parent PIDs. A process whose parent is not in the list
+ * (ex: PID 1, kernel threads, or a parent that exited between two reads) is a root.
+ * Siblings are sorted by the CPU usage of their subtree then by its memory, the heaviest first
+ */
+func (processes Processes) Tree() []*ProcessNode {
+	nodes := make(map[int32]*ProcessNode, len(processes))
+	for _, procInfo := range processes {
+		nodes[procInfo.PID] = &ProcessNode{ProcessInfo: procInfo, Children: []*ProcessNode{}}
+	}
+
+	var roots []*ProcessNode
+	for _, procInfo := range processes {
+		node := nodes[procInfo.PID]
+		parent, ok := nodes[procInfo.PPID]
+		if !ok || procInfo.PPID == procInfo.PID {
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	//PIDs are reused, so a snapshot taken while processes come and go may contain a cycle. Its processes
+	//cannot be reached from a root: break the cycle by turning one of them into a root
+	visited := make(map[int32]bool, len(nodes))
+	var visit func(node *ProcessNode)
+	visit = func(node *ProcessNode) {
+		visited[node.PID] = true
+		for _, child := range node.Children {
+			visit(child)
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+	for _, procInfo := range processes {
+		if visited[procInfo.PID] {
+			continue
+		}
+		node := nodes[procInfo.PID]
+		parent := nodes[procInfo.PPID]
+		for i, child := range parent.Children {
+			if child == node {
+				parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+				break
+			}
+		}
+		roots = append(roots, node)
+		visit(node)
+	}
+
+	for _, root := range roots {
+		aggregate(root)
+	}
+	sortNodes(roots)
+	return roots
+}
+
+// Compute the usage of the subtree of a node
+func aggregate(node *ProcessNode) {
+	node.TreeCpuPercent = node.CpuUsagePercent
+	node.TreeMemoryUsed = node.MemoryUsed
+	node.TreeThreads = node.NumberOfThreadUsed
+	node.Descendants = 0
+	for _, child := range node.Children {
+		aggregate(child)
+		node.TreeCpuPercent += child.TreeCpuPercent
+		node.TreeMemoryUsed += child.TreeMemoryUsed
+		node.TreeThreads += child.TreeThreads
+		node.Descendants += child.Descendants + 1
+	}
+}
+
+func sortNodes(nodes []*ProcessNode) {
+	slices.SortStableFunc(nodes, func(a, b *ProcessNode) int {
+		return cmp.Or(cmp.Compare(b.TreeCpuPercent, a.TreeCpuPercent), cmp.Compare(b.TreeMemoryUsed, a.TreeMemoryUsed), cmp.Compare(a.PID, b.PID))
+	})
+	for _, node := range nodes {
+		sortNodes(node.Children)
+	}
+}
+
+// Visit the nodes depth first, every node before its children
+func WalkTree(nodes []*ProcessNode, visit func(node *ProcessNode, depth int)) {
+	var walk func(nodes []*ProcessNode, depth int)
+	walk = func(nodes []*ProcessNode, depth int) {
+		for _, node := range nodes {
+			visit(node, depth)
+			walk(node.Children, depth+1)
+		}
+	}
+	walk(nodes, 0)
+}
+
+// Children of every running process, read from the system
+func runningChildren() (map[int32][]int32, error) {
+	runningProcesses, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[int32][]int32)
+	for _, runningProc := range runningProcesses {
+		ppid, err := runningProc.Ppid()
+		if err != nil || ppid == runningProc.Pid {
+			//Exited in the meantime
+			continue
+		}
+		children[ppid] = append(children[ppid], runningProc.Pid)
+	}
+	return children, nil
+}
+
+// Return the PIDs of the running children of a process
+func Children(pid int32) ([]int32, error) {
+	children, err := runningChildren()
+	if err != nil {
+		return []int32{}, err
+	}
+	return append([]int32{}, children[pid]...), nil
+}
+
+/*
+ * Return the PIDs of the running descendants of a process, read from the system rather than from a snapshot
+ * so the children forked since the last collection are included. Parents come before their children
+ */
+func Descendants(pid int32) ([]int32, error) {
+	children, err := runningChildren()
+	if err != nil {
+		return nil, err
+	}
+
+	var descendants []int32
+	seen := map[int32]bool{pid: true}
+	queue := []int32{pid}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, child := range children[parent] {
+			if !seen[child] {
+				seen[child] = true
+				descendants = append(descendants, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	return descendants, nil
+}
@@ -1,6 +1,9 @@
 package hardware
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 const (
 	GB float64 = 1024 * 1024 * 1024
@@ -21,3 +24,16 @@ func ConvertByte(value uint64) string {
 		return fmt.Sprintf("%d B", uint32(value))
 	}
 }
+
+// Format a rate in bytes per second
+func ConvertRate(value float64) string {
+	return ConvertByte(uint64(max(0, value))) + "/s"
+}
+
+// Format a timestamp for the dashboard, a zero time mean it never happened
+func FormatTime(t time.Time) string {
+	if t.IsZero() {
+		return "Never"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
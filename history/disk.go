@@ -0,0 +1,370 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sys/hardware"
+	"time"
+)
+
+// How often the open segments are synced to disk, data written since the last sync may be lost on power failure
+const SYNC_INTERVAL = 10 * time.Second
+
+/*
+ * A Level is one resolution of the on-disk storage. The first level store every raw collection,
+ * the next ones store aggregates (min, max, average) built when a segment of the previous level is closed.
+ * Each level is split into segment files covering Span, and a segment is deleted once it is older than Retention
+ */
+type Level struct {
+	Name       string        //Prefix of the segment files
+	Resolution time.Duration //Size of the aggregation buckets, 0 for raw data
+	Retention  time.Duration //How long the data of this level is kept
+	Span       time.Duration //Time covered by one segment file
+}
+
+var DefaultLevels = []Level{
+	{Name: "raw", Resolution: 0, Retention: 24 * time.Hour, Span: time.Hour},
+	{Name: "1m", Resolution: time.Minute, Retention: 8 * 24 * time.Hour, Span: 24 * time.Hour},
+	{Name: "1h", Resolution: time.Hour, Retention: 90 * 24 * time.Hour, Span: 7 * 24 * time.Hour},
+}
+
+type level struct {
+	Level
+	writer *segmentWriter //Segment currently appended to, nil if none is open
+	last   time.Time      //Time of the last record written in this level
+}
+
+// A segment file found on disk
+type segmentFile struct {
+	path  string
+	start time.Time
+}
+
+/*
+ * DiskStore persist the samples in append-only segment files, so the history survive a restart.
+ * Old data is rolled up into coarser levels and deleted after the retention of its level
+ */
+type DiskStore struct {
+	sync.Mutex
+	dir      string
+	levels   []*level
+	lastSync time.Time
+}
+
+/*
+ * Open (or create) the storage in dir. Recovery after a crash:
+ * 1. The last segment of every level is scanned and a half written record at its end is cut off
+ * 2. Closed segments which have not been rolled up into the next level yet are rolled up
+ * 3. Segments older than the retention are deleted
+ */
+func OpenDiskStore(dir string, levels []Level) (*DiskStore, error) {
+	if len(levels) == 0 || levels[0].Resolution != 0 {
+		return nil, fmt.Errorf("the first storage level must store raw data")
+	}
+	for i, lvl := range levels {
+		if lvl.Span <= 0 || lvl.Retention < lvl.Span {
+			return nil, fmt.Errorf("storage level %s: the retention must be longer than the span", lvl.Name)
+		}
+		if i > 0 && lvl.Resolution <= levels[i-1].Resolution {
+			return nil, fmt.Errorf("storage level %s: the resolution must be coarser than the previous level", lvl.Name)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	store := &DiskStore{dir: dir}
+	now := time.Now()
+
+	//Reopen the last segment of every level
+	for _, config := range levels {
+		lvl := &level{Level: config}
+		store.levels = append(store.levels, lvl)
+
+		segments, err := store.segments(lvl)
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) == 0 {
+			continue
+		}
+
+		newest := segments[len(segments)-1]
+		writer, last, err := openSegment(newest.path, newest.start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to recover segment %s: %w", newest.path, err)
+		}
+		lvl.last = last
+
+		if now.Before(newest.start.Add(lvl.Span)) {
+			//Still the current segment, keep appending to it
+			lvl.writer = writer
+		} else if err = writer.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	//Roll up what was not rolled up before the previous shutdown
+	for i, lvl := range store.levels[:len(store.levels)-1] {
+		next := store.levels[i+1]
+
+		segments, err := store.segments(lvl)
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range segments {
+			if lvl.writer != nil && segment.path == lvl.writer.path {
+				continue
+			}
+
+			//Bucket of the last point the segment can contain, if it is after the last bucket of the next level it is missing
+			lastBucket := segment.start.Add(lvl.Span - 1).Truncate(next.Resolution)
+			if next.last.IsZero() || lastBucket.After(next.last) {
+				if err = store.rollup(i, segment.path); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if err := store.enforceRetention(now); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Return the segment files of a level, sorted by start time
+func (store *DiskStore) segments(lvl *level) ([]segmentFile, error) {
+	paths, err := filepath.Glob(filepath.Join(store.dir, lvl.Name+"-*.seg"))
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segmentFile
+	for _, path := range paths {
+		raw := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), lvl.Name+"-"), ".seg")
+		seconds, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			continue //Not one of our files
+		}
+		segments = append(segments, segmentFile{path: path, start: time.Unix(seconds, 0)})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start.Before(segments[j].start)
+	})
+	return segments, nil
+}
+
+// Make sure the open segment of level i cover t, closing (and rolling up) the current one if it is over
+func (store *DiskStore) rotate(i int, t time.Time) error {
+	lvl := store.levels[i]
+
+	if lvl.writer != nil && !t.Before(lvl.writer.start.Add(lvl.Span)) {
+		closed := lvl.writer.path
+		if err := lvl.writer.close(); err != nil {
+			return err
+		}
+		lvl.writer = nil
+
+		if i+1 < len(store.levels) {
+			if err := store.rollup(i, closed); err != nil {
+				return err
+			}
+		}
+		if err := store.enforceRetention(t); err != nil {
+			return err
+		}
+	}
+
+	if lvl.writer == nil {
+		start := t.Truncate(lvl.Span)
+		path := filepath.Join(store.dir, fmt.Sprintf("%s-%d.seg", lvl.Name, start.Unix()))
+
+		writer, _, err := openSegment(path, start)
+		if err != nil {
+			return err
+		}
+		lvl.writer = writer
+	}
+
+	return nil
+}
+
+// Aggregate a closed segment of level i into the buckets of level i+1
+func (store *DiskStore) rollup(i int, path string) error {
+	next := store.levels[i+1]
+
+	buckets := make(map[time.Time]map[string]*aggregate)
+	err := readSegment(path, "", func(t time.Time, entries []entry) {
+		bucket := t.Truncate(next.Resolution)
+		if !next.last.IsZero() && !bucket.After(next.last) {
+			return //Already rolled up
+		}
+
+		values, ok := buckets[bucket]
+		if !ok {
+			values = make(map[string]*aggregate)
+			buckets[bucket] = values
+		}
+		for _, e := range entries {
+			agg, ok := values[e.name]
+			if !ok {
+				agg = &aggregate{}
+				values[e.name] = agg
+			}
+			agg.merge(e.value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	times := make([]time.Time, 0, len(buckets))
+	for t := range buckets {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(a, b int) bool {
+		return times[a].Before(times[b])
+	})
+
+	for _, t := range times {
+		if err = store.rotate(i+1, t); err != nil {
+			return err
+		}
+
+		entries := make([]entry, 0, len(buckets[t]))
+		for name, agg := range buckets[t] {
+			entries = append(entries, entry{name: name, value: *agg})
+		}
+		if err = next.writer.writeAggregate(t, entries); err != nil {
+			return err
+		}
+		next.last = t
+	}
+
+	//The rollup must be durable before the source segment can be deleted by the retention
+	if next.writer != nil {
+		return next.writer.sync()
+	}
+	return nil
+}
+
+// Delete the segments whose whole span is older than the retention of their level
+func (store *DiskStore) enforceRetention(now time.Time) error {
+	for _, lvl := range store.levels {
+		segments, err := store.segments(lvl)
+		if err != nil {
+			return err
+		}
+
+		for _, segment := range segments {
+			if lvl.writer != nil && segment.path == lvl.writer.path {
+				continue
+			}
+			if segment.start.Add(lvl.Span).Before(now.Add(-lvl.Retention)) {
+				if err = os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Append the samples of one collection
+func (store *DiskStore) Append(t time.Time, samples []hardware.Sample) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if err := store.rotate(0, t); err != nil {
+		return err
+	}
+
+	raw := store.levels[0]
+	if err := raw.writer.writeRaw(t, samples); err != nil {
+		return err
+	}
+	raw.last = t
+
+	//Flush every time so queries see the data, but only sync from time to time
+	if t.Sub(store.lastSync) < SYNC_INTERVAL {
+		return raw.writer.flush()
+	}
+	store.lastSync = t
+	return raw.writer.sync()
+}
+
+/*
+ * Return the points of a metric in [from, to], downsampled into buckets of step.
+ * The finest level still covering from is used, so old ranges are answered with aggregated data.
+ * Return false if the metric has no point in the range
+ */
+func (store *DiskStore) Query(name string, from, to time.Time, step time.Duration) ([]Bucket, bool) {
+	store.Lock()
+
+	//Pick the finest level whose retention cover the start of the range
+	now := time.Now()
+	lvl := store.levels[len(store.levels)-1]
+	for _, candidate := range store.levels {
+		if !from.Before(now.Add(-candidate.Retention)) {
+			lvl = candidate
+			break
+		}
+	}
+
+	//Flush the open segment so the latest records are readable
+	if lvl.writer != nil {
+		lvl.writer.flush()
+	}
+
+	segments, err := store.segments(lvl)
+	store.Unlock()
+	if err != nil {
+		return nil, false
+	}
+
+	//Read the segments overlapping the range, without holding the lock (closed segments are never modified)
+	buckets := newBucketizer(from, step)
+	for _, segment := range segments {
+		if !segment.start.Before(to) || !segment.start.Add(lvl.Span).After(from) {
+			continue
+		}
+		readSegment(segment.path, name, func(t time.Time, entries []entry) {
+			if t.Before(from) || t.After(to) {
+				return
+			}
+			for _, e := range entries {
+				buckets.add(t, e.value)
+			}
+		})
+	}
+
+	result := buckets.result()
+	return result, len(result) > 0
+}
+
+// Flush and close every open segment
+func (store *DiskStore) Close() error {
+	store.Lock()
+	defer store.Unlock()
+
+	var err error
+	for _, lvl := range store.levels {
+		if lvl.writer == nil {
+			continue
+		}
+		if closeErr := lvl.writer.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		lvl.writer = nil
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sys/hardware"
+	"testing"
+	"time"
+)
+
+var testLevels = []Level{
+	{Name: "raw", Resolution: 0, Retention: 2 * time.Hour, Span: time.Hour},
+	{Name: "1m", Resolution: time.Minute, Retention: 48 * time.Hour, Span: 24 * time.Hour},
+}
+
+func openTestStore(t *testing.T, dir string) *DiskStore {
+	t.Helper()
+	store, err := OpenDiskStore(dir, testLevels)
+	if err != nil {
+		t.Fatalf("OpenDiskStore: %v", err)
+	}
+	return store
+}
+
+func appendValue(t *testing.T, store *DiskStore, at time.Time, value float64) {
+	t.Helper()
+	if err := store.Append(at, []hardware.Sample{{Name: "cpu.usage", Value: value}}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+}
+
+func segmentPaths(t *testing.T, dir string, level string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, level+"-*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestDiskStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+
+	base := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	for i := range 5 {
+		appendValue(t, store, base.Add(time.Duration(i)*time.Second), float64(i))
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//The points survive a restart
+	store = openTestStore(t, dir)
+	defer func() { store.Close() }()
+
+	buckets, ok := store.Query("cpu.usage", base, base.Add(time.Minute), 0)
+	if !ok || len(buckets) != 5 {
+		t.Fatalf("Query = %+v, %v, want 5 points", buckets, ok)
+	}
+	for i, bucket := range buckets {
+		if !bucket.Time.Equal(base.Add(time.Duration(i)*time.Second)) || bucket.Avg != float64(i) || bucket.Count != 1 {
+			t.Errorf("point %d = %+v", i, bucket)
+		}
+	}
+
+	if _, ok = store.Query("memory.used", base, base.Add(time.Minute), 0); ok {
+		t.Error("Query of an unknown metric returned points")
+	}
+}
+
+func TestDiskStoreRollup(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	defer func() { store.Close() }()
+
+	//Two minutes of points every 20 seconds in a segment which is over, then a point closing it
+	start := time.Now().Add(-90 * time.Minute).Truncate(time.Hour)
+	values := []float64{1, 2, 3, 10, 20, 60}
+	for i, value := range values {
+		appendValue(t, store, start.Add(time.Duration(i)*20*time.Second), value)
+	}
+	appendValue(t, store, start.Add(time.Hour), 100)
+
+	paths := segmentPaths(t, dir, "1m")
+	if len(paths) != 1 {
+		t.Fatalf("1m segments = %v, want one", paths)
+	}
+	records := readAll(t, paths[0], "cpu.usage")
+
+	want := []struct {
+		t     time.Time
+		value aggregate
+	}{
+		{start, aggregate{min: 1, max: 3, sum: 6, count: 3}},
+		{start.Add(time.Minute), aggregate{min: 10, max: 60, sum: 90, count: 3}},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("rolled up records = %+v, want %d buckets", records, len(want))
+	}
+	for i, rec := range records {
+		if !rec.t.Equal(want[i].t) || len(rec.entries) != 1 || rec.entries[0].value != want[i].value {
+			t.Errorf("bucket %d = %v %+v, want %v %+v", i, rec.t, rec.entries, want[i].t, want[i].value)
+		}
+	}
+
+	//Reopening does not roll up the closed segment a second time
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store = openTestStore(t, dir)
+	if records = readAll(t, paths[0], "cpu.usage"); len(records) != len(want) {
+		t.Errorf("%d buckets after reopening, want %d", len(records), len(want))
+	}
+}
+
+func TestDiskStoreRetention(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir)
+	defer func() { store.Close() }()
+
+	now := time.Now()
+	old := now.Add(-5 * time.Hour)
+	appendValue(t, store, old, 1)
+	if paths := segmentPaths(t, dir, "raw"); len(paths) != 1 {
+		t.Fatalf("raw segments = %v, want one", paths)
+	}
+
+	//Opening the next segment delete the old one, once rolled up
+	appendValue(t, store, now, 2)
+
+	paths := segmentPaths(t, dir, "raw")
+	if len(paths) != 1 || paths[0] != filepath.Join(dir, "raw-"+strconv.FormatInt(now.Truncate(time.Hour).Unix(), 10)+".seg") {
+		t.Errorf("raw segments = %v, want only the current one", paths)
+	}
+	if paths = segmentPaths(t, dir, "1m"); len(paths) != 1 {
+		t.Fatalf("1m segments = %v, want one", paths)
+	}
+	if records := readAll(t, paths[0], "cpu.usage"); len(records) != 1 || records[0].entries[0].value != rawAggregate(1) {
+		t.Errorf("rolled up records = %+v", records)
+	}
+
+	//A level segment past its retention is deleted when the store is opened
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expired := filepath.Join(dir, "1m-"+strconv.FormatInt(now.Add(-72*time.Hour).Truncate(24*time.Hour).Unix(), 10)+".seg")
+	if err := os.WriteFile(expired, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store = openTestStore(t, dir)
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expired segment still exists (%v)", err)
+	}
+}
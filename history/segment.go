@@ -0,0 +1,361 @@
+package history
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sys/hardware"
+	"time"
+)
+
+/*
+ * Segment file format. A segment is an append-only sequence of records:
+ *
+ *	[4 bytes payload length][4 bytes CRC32 of payload][payload]
+ *
+ * The payload start with the record kind (1 byte) and the record time (8 bytes, unix nano), followed by
+ * a uvarint number of entries:
+ *	names:     (uvarint id, uvarint length, name bytes)...   define the ids used by the next records
+ *	raw:       (uvarint id, float64 value)...                one collection
+ *	aggregate: (uvarint id, float64 min, float64 max, float64 sum, uvarint count)...   one bucket
+ *
+ * Ids are local to a segment, so every segment can be read on its own. A record is only valid if its
+ * length and checksum match, which let us detect (and cut) a record half written during a crash
+ */
+
+const (
+	recordNames     byte = 1
+	recordRaw       byte = 2
+	recordAggregate byte = 3
+
+	recordHeaderSize = 8
+	maxRecordSize    = 64 * 1024 * 1024
+)
+
+var errCorrupted = errors.New("corrupted record")
+
+// Aggregated values of a metric over a time bucket (a raw point is an aggregate of count 1)
+type aggregate struct {
+	min   float64
+	max   float64
+	sum   float64
+	count uint64
+}
+
+func rawAggregate(value float64) aggregate {
+	return aggregate{min: value, max: value, sum: value, count: 1}
+}
+
+func (agg *aggregate) merge(other aggregate) {
+	if agg.count == 0 {
+		*agg = other
+		return
+	}
+	agg.min = math.Min(agg.min, other.min)
+	agg.max = math.Max(agg.max, other.max)
+	agg.sum += other.sum
+	agg.count += other.count
+}
+
+// A metric value inside a record
+type entry struct {
+	name  string
+	value aggregate
+}
+
+/*---Writer---*/
+
+type segmentWriter struct {
+	path   string
+	start  time.Time         //Start of the time span covered by the segment
+	file   *os.File          //The underlying file, opened in append mode
+	buffer *bufio.Writer     //Buffer the records until the next flush
+	ids    map[string]uint64 //Id of every name already defined in the segment
+}
+
+/*
+ * Open a segment for appending, creating it if needed. An existing segment is scanned first:
+ * the id of its names are loaded and a half written record at the end (after a crash) is cut off.
+ * Return the time of the last valid record (zero if there is none)
+ */
+func openSegment(path string, start time.Time) (*segmentWriter, time.Time, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	//Scan the existing records
+	reader := newSegmentReader(file, "")
+	var last time.Time
+	for {
+		t, _, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			//Everything after the last valid record is garbage from an interrupted write
+			if err = file.Truncate(reader.offset); err != nil {
+				file.Close()
+				return nil, time.Time{}, err
+			}
+			break
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+
+	if _, err = file.Seek(reader.offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, time.Time{}, err
+	}
+
+	writer := &segmentWriter{
+		path:   path,
+		start:  start,
+		file:   file,
+		buffer: bufio.NewWriter(file),
+		ids:    make(map[string]uint64, len(reader.names)),
+	}
+	for id, name := range reader.names {
+		writer.ids[name] = id
+	}
+
+	return writer, last, nil
+}
+
+// Return the id of the name, and add it to the list of names to define if it is new
+func (writer *segmentWriter) id(name string, newNames *[]byte, count *uint64) uint64 {
+	id, ok := writer.ids[name]
+	if !ok {
+		id = uint64(len(writer.ids))
+		writer.ids[name] = id
+		*newNames = binary.AppendUvarint(*newNames, id)
+		*newNames = binary.AppendUvarint(*newNames, uint64(len(name)))
+		*newNames = append(*newNames, name...)
+		*count++
+	}
+	return id
+}
+
+func (writer *segmentWriter) writeRaw(t time.Time, samples []hardware.Sample) error {
+	var (
+		newNames []byte
+		newCount uint64
+		body     []byte
+	)
+	for _, sample := range samples {
+		body = binary.AppendUvarint(body, writer.id(sample.Name, &newNames, &newCount))
+		body = binary.LittleEndian.AppendUint64(body, math.Float64bits(sample.Value))
+	}
+
+	return writer.writeEntries(t, recordRaw, newNames, newCount, body, uint64(len(samples)))
+}
+
+func (writer *segmentWriter) writeAggregate(t time.Time, entries []entry) error {
+	var (
+		newNames []byte
+		newCount uint64
+		body     []byte
+	)
+	for _, e := range entries {
+		body = binary.AppendUvarint(body, writer.id(e.name, &newNames, &newCount))
+		body = binary.LittleEndian.AppendUint64(body, math.Float64bits(e.value.min))
+		body = binary.LittleEndian.AppendUint64(body, math.Float64bits(e.value.max))
+		body = binary.LittleEndian.AppendUint64(body, math.Float64bits(e.value.sum))
+		body = binary.AppendUvarint(body, e.value.count)
+	}
+
+	return writer.writeEntries(t, recordAggregate, newNames, newCount, body, uint64(len(entries)))
+}
+
+// Write the names record (if there are new names) followed by the data record
+func (writer *segmentWriter) writeEntries(t time.Time, kind byte, newNames []byte, newCount uint64, body []byte, count uint64) error {
+	if newCount > 0 {
+		err := writer.writeRecord(recordNames, t, newCount, newNames)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.writeRecord(kind, t, count, body)
+}
+
+func (writer *segmentWriter) writeRecord(kind byte, t time.Time, count uint64, body []byte) error {
+	payload := make([]byte, 0, 1+8+binary.MaxVarintLen64+len(body))
+	payload = append(payload, kind)
+	payload = binary.LittleEndian.AppendUint64(payload, uint64(t.UnixNano()))
+	payload = binary.AppendUvarint(payload, count)
+	payload = append(payload, body...)
+
+	header := make([]byte, 0, recordHeaderSize)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(payload)))
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(payload))
+
+	if _, err := writer.buffer.Write(header); err != nil {
+		return err
+	}
+	_, err := writer.buffer.Write(payload)
+	return err
+}
+
+// Write the buffered records to the file (they are visible to readers but not yet durable)
+func (writer *segmentWriter) flush() error {
+	return writer.buffer.Flush()
+}
+
+// Flush and make the records durable
+func (writer *segmentWriter) sync() error {
+	if err := writer.flush(); err != nil {
+		return err
+	}
+	return writer.file.Sync()
+}
+
+func (writer *segmentWriter) close() error {
+	err := writer.sync()
+	if closeErr := writer.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+/*---Reader---*/
+
+type segmentReader struct {
+	reader *bufio.Reader
+	filter string            //If not empty, only the entries of this metric are returned
+	names  map[uint64]string //Names defined so far in the segment
+	offset int64             //Offset right after the last valid record
+}
+
+func newSegmentReader(reader io.Reader, filter string) *segmentReader {
+	return &segmentReader{
+		reader: bufio.NewReader(reader),
+		filter: filter,
+		names:  make(map[uint64]string),
+	}
+}
+
+/*
+ * Return the time and entries of the next data record (names records are consumed internally).
+ * Return io.EOF at the end of the segment and errCorrupted if the next record is invalid
+ */
+func (reader *segmentReader) next() (time.Time, []entry, error) {
+	for {
+		header := make([]byte, recordHeaderSize)
+		n, err := io.ReadFull(reader.reader, header)
+		if err == io.EOF {
+			return time.Time{}, nil, io.EOF
+		}
+		if err != nil || n != recordHeaderSize {
+			return time.Time{}, nil, errCorrupted
+		}
+
+		size := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		if size < 1+8 || size > maxRecordSize {
+			return time.Time{}, nil, errCorrupted
+		}
+
+		payload := make([]byte, size)
+		if _, err = io.ReadFull(reader.reader, payload); err != nil {
+			return time.Time{}, nil, errCorrupted
+		}
+		if crc32.ChecksumIEEE(payload) != checksum {
+			return time.Time{}, nil, errCorrupted
+		}
+
+		kind, t, entries, err := reader.decode(payload)
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		reader.offset += int64(recordHeaderSize + size)
+
+		if kind != recordNames {
+			return t, entries, nil
+		}
+	}
+}
+
+func (reader *segmentReader) decode(payload []byte) (byte, time.Time, []entry, error) {
+	kind := payload[0]
+	t := time.Unix(0, int64(binary.LittleEndian.Uint64(payload[1:9])))
+	data := payload[9:]
+
+	//Small helpers reading from data, any failure mark the record as corrupted
+	failed := false
+	readUvarint := func() uint64 {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			failed = true
+			return 0
+		}
+		data = data[n:]
+		return value
+	}
+	readFloat := func() float64 {
+		if len(data) < 8 {
+			failed = true
+			return 0
+		}
+		value := math.Float64frombits(binary.LittleEndian.Uint64(data))
+		data = data[8:]
+		return value
+	}
+
+	count := readUvarint()
+	var entries []entry
+	for i := uint64(0); i < count && !failed; i++ {
+		id := readUvarint()
+		switch kind {
+		case recordNames:
+			length := readUvarint()
+			if failed || uint64(len(data)) < length {
+				failed = true
+				break
+			}
+			reader.names[id] = string(data[:length])
+			data = data[length:]
+		case recordRaw:
+			value := readFloat()
+			if name := reader.names[id]; reader.filter == "" || name == reader.filter {
+				entries = append(entries, entry{name: name, value: rawAggregate(value)})
+			}
+		case recordAggregate:
+			value := aggregate{min: readFloat(), max: readFloat(), sum: readFloat(), count: readUvarint()}
+			if name := reader.names[id]; reader.filter == "" || name == reader.filter {
+				entries = append(entries, entry{name: name, value: value})
+			}
+		default:
+			failed = true
+		}
+	}
+
+	if failed {
+		return 0, time.Time{}, nil, errCorrupted
+	}
+	return kind, t, entries, nil
+}
+
+// Read every data record of a segment file, stopping silently at the first invalid record
+func readSegment(path string, filter string, fn func(t time.Time, entries []entry)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := newSegmentReader(file, filter)
+	for {
+		t, entries, err := reader.next()
+		if err != nil {
+			//io.EOF or a record still being written / cut by a crash, either way there is nothing more to read
+			return nil
+		}
+		fn(t, entries)
+	}
+}
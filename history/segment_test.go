@@ -0,0 +1,225 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"sys/hardware"
+	"testing"
+	"time"
+)
+
+type record struct {
+	t       time.Time
+	entries []entry
+}
+
+func readAll(t *testing.T, path string, filter string) []record {
+	t.Helper()
+	var records []record
+	err := readSegment(path, filter, func(recordTime time.Time, entries []entry) {
+		records = append(records, record{t: recordTime, entries: entries})
+	})
+	if err != nil {
+		t.Fatalf("readSegment: %v", err)
+	}
+	return records
+}
+
+func TestSegmentRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raw-0.seg")
+	start := time.Unix(1700000000, 0)
+
+	writer, last, err := openSegment(path, start)
+	if err != nil {
+		t.Fatalf("openSegment: %v", err)
+	}
+	if !last.IsZero() {
+		t.Fatalf("new segment: last = %v, want zero", last)
+	}
+
+	t1, t2, t3 := start.Add(time.Second), start.Add(2*time.Second), start.Add(time.Minute)
+	if err = writer.writeRaw(t1, []hardware.Sample{{Name: "cpu.usage", Value: 12.5}, {Name: "memory.used", Value: 1024}}); err != nil {
+		t.Fatal(err)
+	}
+	//Reuse a defined name and define a new one
+	if err = writer.writeRaw(t2, []hardware.Sample{{Name: "cpu.usage", Value: 50}, {Name: "load.1", Value: 0.75}}); err != nil {
+		t.Fatal(err)
+	}
+	agg := aggregate{min: 1, max: 9, sum: 15, count: 3}
+	if err = writer.writeAggregate(t3, []entry{{name: "cpu.usage", value: agg}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAll(t, path, "")
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	want := []record{
+		{t1, []entry{{"cpu.usage", rawAggregate(12.5)}, {"memory.used", rawAggregate(1024)}}},
+		{t2, []entry{{"cpu.usage", rawAggregate(50)}, {"load.1", rawAggregate(0.75)}}},
+		{t3, []entry{{"cpu.usage", agg}}},
+	}
+	for i, rec := range records {
+		if !rec.t.Equal(want[i].t) {
+			t.Errorf("record %d: time = %v, want %v", i, rec.t, want[i].t)
+		}
+		if len(rec.entries) != len(want[i].entries) {
+			t.Fatalf("record %d: %d entries, want %d", i, len(rec.entries), len(want[i].entries))
+		}
+		for j, e := range rec.entries {
+			if e != want[i].entries[j] {
+				t.Errorf("record %d entry %d = %+v, want %+v", i, j, e, want[i].entries[j])
+			}
+		}
+	}
+
+	//Only the entries of the filtered metric are returned
+	records = readAll(t, path, "load.1")
+	if len(records) != 3 || len(records[0].entries) != 0 || len(records[1].entries) != 1 || len(records[2].entries) != 0 {
+		t.Fatalf("filtered read = %+v", records)
+	}
+
+	//Reopening keep the ids, so the names written after are still resolved
+	writer, last, err = openSegment(path, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Equal(t3) {
+		t.Errorf("reopened segment: last = %v, want %v", last, t3)
+	}
+	t4 := start.Add(2 * time.Minute)
+	if err = writer.writeRaw(t4, []hardware.Sample{{Name: "memory.used", Value: 2048}, {Name: "disk.used", Value: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records = readAll(t, path, "")
+	if len(records) != 4 {
+		t.Fatalf("got %d records after reopening, want 4", len(records))
+	}
+	got := records[3].entries
+	if len(got) != 2 || got[0] != (entry{"memory.used", rawAggregate(2048)}) || got[1] != (entry{"disk.used", rawAggregate(3)}) {
+		t.Errorf("record written after reopening = %+v", got)
+	}
+}
+
+func TestSegmentTruncatesTornTail(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	t1, t2 := start.Add(time.Second), start.Add(2*time.Second)
+
+	//Write two records and return the size of the file after the first one and after both
+	writeSegment := func(path string) (int64, int64) {
+		writer, _, err := openSegment(path, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = writer.writeRaw(t1, []hardware.Sample{{Name: "cpu.usage", Value: 1}}); err != nil {
+			t.Fatal(err)
+		}
+		if err = writer.flush(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = writer.writeRaw(t2, []hardware.Sample{{Name: "cpu.usage", Value: 2}}); err != nil {
+			t.Fatal(err)
+		}
+		if err = writer.close(); err != nil {
+			t.Fatal(err)
+		}
+		full, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size(), full.Size()
+	}
+
+	tests := []struct {
+		name     string
+		damage   func(path string, first, full int64) error
+		wantLast time.Time
+		keep     func(first, full int64) int64 //Expected size once reopened
+	}{
+		{
+			name: "header without payload",
+			damage: func(path string, first, full int64) error {
+				//A header announcing a payload which was never written
+				file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				_, err = file.Write([]byte{100, 0, 0, 0, 1, 2, 3, 4, 2, 0})
+				return err
+			},
+			wantLast: t2,
+			keep:     func(first, full int64) int64 { return full },
+		},
+		{
+			name: "cut last record",
+			damage: func(path string, first, full int64) error {
+				return os.Truncate(path, full-3)
+			},
+			wantLast: t1,
+			keep:     func(first, full int64) int64 { return first },
+		},
+		{
+			name: "corrupted checksum",
+			damage: func(path string, first, full int64) error {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				data[full-1] ^= 0xff
+				return os.WriteFile(path, data, 0644)
+			},
+			wantLast: t1,
+			keep:     func(first, full int64) int64 { return first },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "raw-0.seg")
+			first, full := writeSegment(path)
+			if err := test.damage(path, first, full); err != nil {
+				t.Fatal(err)
+			}
+
+			writer, last, err := openSegment(path, start)
+			if err != nil {
+				t.Fatalf("openSegment: %v", err)
+			}
+			if !last.Equal(test.wantLast) {
+				t.Errorf("last = %v, want %v", last, test.wantLast)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != test.keep(first, full) {
+				t.Errorf("size after reopening = %d, want %d", info.Size(), test.keep(first, full))
+			}
+
+			//New records go right after the last valid one
+			t3 := start.Add(time.Minute)
+			if err = writer.writeRaw(t3, []hardware.Sample{{Name: "cpu.usage", Value: 3}}); err != nil {
+				t.Fatal(err)
+			}
+			if err = writer.close(); err != nil {
+				t.Fatal(err)
+			}
+			records := readAll(t, path, "")
+			if len(records) == 0 || !records[len(records)-1].t.Equal(t3) {
+				t.Fatalf("records after reopening = %+v, want the new record last", records)
+			}
+		})
+	}
+}
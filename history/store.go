@@ -0,0 +1,206 @@
+package history
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sys/hardware"
+	"time"
+)
+
+// A single value at a point in time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+/*
+ * Fixed size circular buffer of points, the oldest point is overwritten when it is full.
+ * The points are allocated as they come, so a short lived metric (ex: a process) does not cost the whole retention
+ */
+type ring struct {
+	points   []Point
+	capacity int //Maximum number of points
+	start    int //Index of the oldest point
+	count    int //Number of points currently stored
+}
+
+func newRing(capacity int) *ring {
+	return &ring{capacity: capacity}
+}
+
+func (r *ring) push(point Point) {
+	if len(r.points) < r.capacity {
+		//Still growing: the points are in order from index 0
+		r.points = append(r.points, point)
+		r.count++
+		return
+	}
+
+	end := (r.start + r.count) % len(r.points)
+	r.points[end] = point
+	if r.count < len(r.points) {
+		r.count++
+	} else {
+		r.start = (r.start + 1) % len(r.points)
+	}
+}
+
+// Return the point at position i (0 is the oldest)
+func (r *ring) at(i int) Point {
+	return r.points[(r.start+i)%len(r.points)]
+}
+
+func (r *ring) last() Point {
+	return r.at(r.count - 1)
+}
+
+/*
+ * Store keep the recent history of every sample in memory. Each metric has its own ring buffer
+ * sized to hold the retention period at the collection interval, metrics that stop receiving
+ * values (ex: exited processes) are dropped once their last point is older than the retention
+ */
+type Store struct {
+	sync.RWMutex
+	retention time.Duration
+	capacity  int              //Number of points kept per metric
+	series    map[string]*ring //Metric name -> points
+}
+
+func NewStore(retention, interval time.Duration) *Store {
+	capacity := 1
+	if interval > 0 {
+		capacity = max(1, int(retention/interval))
+	}
+
+	return &Store{
+		retention: retention,
+		capacity:  capacity,
+		series:    make(map[string]*ring),
+	}
+}
+
+func (store *Store) Retention() time.Duration {
+	return store.retention
+}
+
+// Add all the samples of one collection, then drop the metrics that disappeared for longer than the retention
+func (store *Store) Record(t time.Time, samples []hardware.Sample) {
+	store.Lock()
+	defer store.Unlock()
+
+	for _, sample := range samples {
+		series, ok := store.series[sample.Name]
+		if !ok {
+			series = newRing(store.capacity)
+			store.series[sample.Name] = series
+		}
+		series.push(Point{Time: t, Value: sample.Value})
+	}
+
+	cutoff := t.Add(-store.retention)
+	for name, series := range store.series {
+		if series.last().Time.Before(cutoff) {
+			delete(store.series, name)
+		}
+	}
+}
+
+// Return the name of every metric currently stored, sorted, optionally filtered by prefix
+func (store *Store) Names(prefix string) []string {
+	store.RLock()
+	defer store.RUnlock()
+
+	names := make([]string, 0, len(store.series))
+	for name := range store.series {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Aggregated points of a time bucket
+type Bucket struct {
+	Time  time.Time `json:"time"` //Start of the bucket
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int       `json:"count"` //Number of raw points in the bucket
+}
+
+/*
+ * Return the points of a metric in [from, to], downsampled into buckets of step.
+ * With a step of 0 every raw point is returned as its own bucket.
+ * Return false if the metric does not exist
+ */
+func (store *Store) Query(name string, from, to time.Time, step time.Duration) ([]Bucket, bool) {
+	store.RLock()
+	defer store.RUnlock()
+
+	series, ok := store.series[name]
+	if !ok {
+		return nil, false
+	}
+
+	buckets := newBucketizer(from, step)
+	for i := 0; i < series.count; i++ {
+		point := series.at(i)
+		if point.Time.Before(from) || point.Time.After(to) {
+			continue
+		}
+		buckets.add(point.Time, rawAggregate(point.Value))
+	}
+
+	return buckets.result(), true
+}
+
+// Group aggregated values into buckets of step starting at from (step 0 keep every value on its own)
+type bucketizer struct {
+	from    time.Time
+	step    time.Duration
+	buckets map[time.Time]*aggregate
+}
+
+func newBucketizer(from time.Time, step time.Duration) *bucketizer {
+	return &bucketizer{
+		from:    from,
+		step:    step,
+		buckets: make(map[time.Time]*aggregate),
+	}
+}
+
+func (buckets *bucketizer) add(t time.Time, value aggregate) {
+	//Find the start of the bucket the value belong to
+	bucketTime := t
+	if buckets.step > 0 {
+		bucketTime = buckets.from.Add(t.Sub(buckets.from) / buckets.step * buckets.step)
+	}
+
+	agg, ok := buckets.buckets[bucketTime]
+	if !ok {
+		agg = &aggregate{}
+		buckets.buckets[bucketTime] = agg
+	}
+	agg.merge(value)
+}
+
+// Return the buckets in time order
+func (buckets *bucketizer) result() []Bucket {
+	result := make([]Bucket, 0, len(buckets.buckets))
+	for t, agg := range buckets.buckets {
+		result = append(result, Bucket{
+			Time:  t,
+			Min:   agg.min,
+			Max:   agg.max,
+			Avg:   agg.sum / float64(agg.count),
+			Count: int(agg.count),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result
+}
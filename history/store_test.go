@@ -0,0 +1,53 @@
+package history
+
+import (
+	"sys/hardware"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(3)
+	start := time.Unix(1700000000, 0)
+
+	for i := range 5 {
+		r.push(Point{Time: start.Add(time.Duration(i) * time.Second), Value: float64(i)})
+
+		//The points are allocated as they come, never more than the capacity
+		if want := min(i+1, 3); r.count != want || len(r.points) != want {
+			t.Fatalf("after %d points: count = %d, %d allocated, want %d", i+1, r.count, len(r.points), want)
+		}
+		if r.last().Value != float64(i) {
+			t.Errorf("after %d points: last = %v, want %d", i+1, r.last().Value, i)
+		}
+	}
+
+	//The oldest points were overwritten
+	for i := range r.count {
+		if want := float64(i + 2); r.at(i).Value != want {
+			t.Errorf("at(%d) = %v, want %v", i, r.at(i).Value, want)
+		}
+	}
+}
+
+func TestStoreRecord(t *testing.T) {
+	store := NewStore(time.Minute, time.Second)
+	start := time.Unix(1700000000, 0)
+
+	store.Record(start, []hardware.Sample{{Name: "cpu.usage", Value: 1}, {Name: "process.cpu:42", Value: 2}})
+	store.Record(start.Add(time.Second), []hardware.Sample{{Name: "cpu.usage", Value: 3}})
+	if series := store.series["process.cpu:42"]; cap(series.points) >= store.capacity {
+		t.Errorf("a metric with one point allocated %d points", cap(series.points))
+	}
+
+	buckets, ok := store.Query("cpu.usage", start, start.Add(time.Minute), 0)
+	if !ok || len(buckets) != 2 || buckets[0].Avg != 1 || buckets[1].Avg != 3 {
+		t.Errorf("Query = %+v, %v", buckets, ok)
+	}
+
+	//A metric without new value for longer than the retention is dropped
+	store.Record(start.Add(2*time.Minute), []hardware.Sample{{Name: "cpu.usage", Value: 4}})
+	if names := store.Names(""); len(names) != 1 || names[0] != "cpu.usage" {
+		t.Errorf("Names = %v, want only cpu.usage", names)
+	}
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sys/config"
+)
+
+/*
+ * Configure the default slog logger from the configuration: level, text or JSON records,
+ * standard output or a file rotated by size. The returned closer close the file (if any)
+ */
+func Setup(cfg config.LogConfig) (io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+
+	var (
+		writer io.Writer = os.Stdout
+		closer io.Closer = nopCloser{}
+		file   *rotatingFile
+	)
+	if cfg.File != "" {
+		var err error
+		file, err = openRotatingFile(cfg.File, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		writer, closer = file, file
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch cfg.Format {
+	case "text":
+		handler = slog.NewTextHandler(writer, options)
+	case "json":
+		handler = slog.NewJSONHandler(writer, options)
+	default:
+		if file != nil {
+			file.Close()
+		}
+		return nil, fmt.Errorf("invalid log format %q (text or json)", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
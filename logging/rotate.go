@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+ * Log file rotated by size: when a write would exceed maxSize, the file is renamed to <file>.1
+ * (the previous <file>.1 become <file>.2 and so on, keeping maxBackups files) and a new file is started
+ */
+type rotatingFile struct {
+	sync.Mutex
+	path       string
+	maxSize    int64 //0 = never rotate
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	rotating := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rotating.open(); err != nil {
+		return nil, err
+	}
+	return rotating, nil
+}
+
+func (rotating *rotatingFile) open() error {
+	file, err := os.OpenFile(rotating.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rotating.file = file
+	rotating.size = info.Size()
+	return nil
+}
+
+func (rotating *rotatingFile) Write(data []byte) (int, error) {
+	rotating.Lock()
+	defer rotating.Unlock()
+
+	if rotating.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	//Never rotate an empty file, a single record bigger than the limit is still written
+	if rotating.maxSize > 0 && rotating.size > 0 && rotating.size+int64(len(data)) > rotating.maxSize {
+		if err := rotating.rotate(); err != nil {
+			//Keep logging to the current file rather than losing the records
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", rotating.path, err)
+		}
+	}
+
+	written, err := rotating.file.Write(data)
+	rotating.size += int64(written)
+	return written, err
+}
+
+func (rotating *rotatingFile) rotate() error {
+	if err := rotating.file.Close(); err != nil {
+		return err
+	}
+	rotating.file = nil
+
+	//Shift the backups, the oldest one is overwritten
+	if rotating.maxBackups > 0 {
+		for i := rotating.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", rotating.path, i), fmt.Sprintf("%s.%d", rotating.path, i+1))
+		}
+		if err := os.Rename(rotating.path, rotating.path+".1"); err != nil {
+			rotating.open()
+			return err
+		}
+	} else if err := os.Truncate(rotating.path, 0); err != nil {
+		rotating.open()
+		return err
+	}
+
+	return rotating.open()
+}
+
+func (rotating *rotatingFile) Close() error {
+	rotating.Lock()
+	defer rotating.Unlock()
+
+	if rotating.file == nil {
+		return nil
+	}
+	err := rotating.file.Close()
+	rotating.file = nil
+	return err
+}
@@ -1,9 +1,83 @@
 package main
 
-import "sys/server"
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sys/auth"
+	"sys/config"
+	"sys/logging"
+	"sys/server"
+)
 
 func main() {
-	//Create server and start
-	server := server.NewServer()
-	server.Start()
+	//Helpers for filling the users file
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "hash-password":
+			hashPassword()
+			return
+		case "new-token":
+			newToken()
+			return
+		}
+	}
+
+	//Read the configuration file, environment variables and flags
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Invalid configuration\nError: %v\n", err)
+		os.Exit(2)
+	}
+
+	//Every package log through the default slog logger
+	logFile, err := logging.Setup(cfg.Log)
+	if err != nil {
+		fmt.Printf("Failed to set up logging\nError: %v\n", err)
+		os.Exit(2)
+	}
+	defer logFile.Close()
+
+	//Create server and start, it return once stopped. Exit only here, after the log file is closed
+	server := server.NewServer(cfg)
+	if err = server.Start(); err != nil {
+		slog.Error("Server stopped on error", "error", err)
+		logFile.Close()
+		os.Exit(1)
+	}
+}
+
+// Read a password on the standard input and print its hash
+func hashPassword() {
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fmt.Printf("Failed to read the password\nError: %v\n", err)
+		os.Exit(1)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Println("Password cannot be empty")
+		os.Exit(1)
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		fmt.Printf("Failed to hash the password\nError: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+}
+
+// Print a new API token and the hash to put in the users file
+func newToken() {
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		fmt.Printf("Failed to generate the token\nError: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Token (give it to the client, it is not stored): %s\n", token)
+	fmt.Printf("Hash (add it to the user's tokens): %s\n", hash)
 }
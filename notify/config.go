@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sys/alert"
+)
+
+/*
+ * Notification configuration, read from a JSON file:
+ *
+ *	{
+ *		"receivers": [
+ *			{"name": "chat", "webhook": {"url": "https://chat.example.com/hook", "body": "{\"text\": {{ json .Title }}}"}},
+ *			{"name": "oncall", "smtp": {"address": "smtp.example.com:587", "from": "sysmon@example.com", "to": ["oncall@example.com"]}},
+ *			{"name": "local", "syslog": {"tag": "sysmon"}}
+ *		],
+ *		"routes": [
+ *			{"receiver": "chat", "severities": ["critical"], "group_wait": "30s", "max_per_hour": 10}
+ *		]
+ *	}
+ */
+type Config struct {
+	Receivers []ReceiverConfig `json:"receivers"`
+	Routes    []RouteConfig    `json:"routes"`
+}
+
+// A receiver has exactly one of the delivery methods set
+type ReceiverConfig struct {
+	Name    string         `json:"name"`
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	Smtp    *SmtpConfig    `json:"smtp,omitempty"`
+	Syslog  *SyslogConfig  `json:"syslog,omitempty"`
+}
+
+// A route send the matching alert events to a receiver
+type RouteConfig struct {
+	Receiver   string         `json:"receiver"`
+	Rules      []string       `json:"rules,omitempty"`        //Only these rules (all if empty)
+	Severities []string       `json:"severities,omitempty"`   //Only these severities (all if empty)
+	GroupWait  alert.Duration `json:"group_wait,omitempty"`   //Events arriving within this window are sent as one notification
+	MaxPerHour int            `json:"max_per_hour,omitempty"` //Maximum notifications per hour, extra ones are dropped (0 = unlimited)
+}
+
+// Read the configuration file. A missing file mean no notification
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return config, config.Validate()
+}
+
+func (config *Config) Validate() error {
+	names := make(map[string]bool)
+	for _, receiver := range config.Receivers {
+		if receiver.Name == "" {
+			return errors.New("receiver without name")
+		}
+		if names[receiver.Name] {
+			return fmt.Errorf("receiver %s is defined twice", receiver.Name)
+		}
+		names[receiver.Name] = true
+
+		methods := 0
+		if receiver.Webhook != nil {
+			methods++
+			if receiver.Webhook.URL == "" {
+				return fmt.Errorf("receiver %s: webhook url is required", receiver.Name)
+			}
+		}
+		if receiver.Smtp != nil {
+			methods++
+			if err := receiver.Smtp.validate(); err != nil {
+				return fmt.Errorf("receiver %s: %w", receiver.Name, err)
+			}
+		}
+		if receiver.Syslog != nil {
+			methods++
+		}
+		if methods != 1 {
+			return fmt.Errorf("receiver %s: exactly one of webhook, smtp or syslog must be set", receiver.Name)
+		}
+	}
+
+	for _, route := range config.Routes {
+		if !names[route.Receiver] {
+			return fmt.Errorf("route to unknown receiver %s", route.Receiver)
+		}
+		if route.GroupWait < 0 || route.MaxPerHour < 0 {
+			return fmt.Errorf("route to %s: group_wait and max_per_hour cannot be negative", route.Receiver)
+		}
+	}
+	return nil
+}
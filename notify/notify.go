@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sys/alert"
+	"time"
+)
+
+const ROUTE_QUEUE_SIZE = 256 //Maximum number of events waiting to be grouped per route
+
+// A batch of alert events delivered as one notification
+type Group struct {
+	Host       string        `json:"host"`
+	Receiver   string        `json:"receiver"`
+	Title      string        `json:"title"` //Short summary, ex: "[vm] 2 firing, 1 resolved"
+	Alerts     []alert.Alert `json:"alerts"`
+	Firing     int           `json:"firing"`     //Number of firing alerts in the group
+	Resolved   int           `json:"resolved"`   //Number of resolved alerts in the group
+	Suppressed int           `json:"suppressed"` //Events dropped by the rate limit since the previous notification
+}
+
+// A delivery method
+type Receiver interface {
+	Send(group *Group) error
+}
+
+func newReceiver(config ReceiverConfig) (Receiver, error) {
+	switch {
+	case config.Webhook != nil:
+		return NewWebhook(*config.Webhook)
+	case config.Smtp != nil:
+		return NewSmtp(*config.Smtp), nil
+	default:
+		return NewSyslog(*config.Syslog)
+	}
+}
+
+type route struct {
+	RouteConfig
+	receiver Receiver
+	events   chan alert.Alert
+	sent     []time.Time //Time of the notifications sent during the last hour, used for rate limiting
+}
+
+func (route *route) match(event alert.Alert) bool {
+	if len(route.Rules) > 0 && !slices.Contains(route.Rules, event.Rule) {
+		return false
+	}
+	if len(route.Severities) > 0 && !slices.Contains(route.Severities, event.Severity) {
+		return false
+	}
+	return true
+}
+
+/*
+ * Dispatcher deliver alert events to the receivers. Every route has its own goroutine which group the
+ * events arriving within group_wait into one notification and apply the rate limit, so a slow receiver
+ * or a storm of alerts never block the collection loop
+ */
+type Dispatcher struct {
+	host   string
+	routes []*route
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(config *Config) (*Dispatcher, error) {
+	host, _ := os.Hostname()
+	dispatcher := &Dispatcher{host: host}
+
+	receivers := make(map[string]Receiver)
+	for _, receiverConfig := range config.Receivers {
+		receiver, err := newReceiver(receiverConfig)
+		if err != nil {
+			return nil, fmt.Errorf("receiver %s: %w", receiverConfig.Name, err)
+		}
+		receivers[receiverConfig.Name] = receiver
+	}
+
+	for _, routeConfig := range config.Routes {
+		route := &route{
+			RouteConfig: routeConfig,
+			receiver:    receivers[routeConfig.Receiver],
+			events:      make(chan alert.Alert, ROUTE_QUEUE_SIZE),
+		}
+		dispatcher.routes = append(dispatcher.routes, route)
+
+		dispatcher.wg.Add(1)
+		go dispatcher.run(route)
+	}
+
+	return dispatcher, nil
+}
+
+// Queue the events for every matching route, never block
+func (dispatcher *Dispatcher) Notify(events []alert.Alert) {
+	for _, event := range events {
+		for _, route := range dispatcher.routes {
+			if !route.match(event) {
+				continue
+			}
+			select {
+			case route.events <- event:
+			default:
+				slog.Warn("Notification queue is full, dropping alert", "receiver", route.Receiver, "rule", event.Rule)
+			}
+		}
+	}
+}
+
+// Send the pending notifications and stop every route
+func (dispatcher *Dispatcher) Close() {
+	for _, route := range dispatcher.routes {
+		close(route.events)
+	}
+	dispatcher.wg.Wait()
+}
+
+func (dispatcher *Dispatcher) run(route *route) {
+	defer dispatcher.wg.Done()
+
+	var (
+		pending    []alert.Alert
+		suppressed int
+		timer      = time.NewTimer(time.Hour)
+	)
+	timer.Stop() //Only started when the first event of a group arrive
+
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+
+		//Rate limit: count the notifications sent during the last hour
+		now := time.Now()
+		route.sent = slices.DeleteFunc(route.sent, func(t time.Time) bool {
+			return now.Sub(t) > time.Hour
+		})
+		if route.MaxPerHour > 0 && len(route.sent) >= route.MaxPerHour {
+			suppressed += len(pending)
+			pending = nil
+			return
+		}
+		route.sent = append(route.sent, now)
+
+		group := dispatcher.newGroup(route.Receiver, pending, suppressed)
+		pending, suppressed = nil, 0
+
+		if err := route.receiver.Send(group); err != nil {
+			slog.Error("Failed to send notification", "receiver", route.Receiver, "alerts", len(group.Alerts), "error", err)
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-route.events:
+			if !ok {
+				//Shutting down, don't wait for the group to complete
+				timer.Stop()
+				flush()
+				return
+			}
+
+			if len(pending) == 0 {
+				timer.Reset(time.Duration(route.GroupWait))
+			}
+			pending = append(pending, event)
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+func (dispatcher *Dispatcher) newGroup(receiver string, alerts []alert.Alert, suppressed int) *Group {
+	group := &Group{
+		Host:       dispatcher.host,
+		Receiver:   receiver,
+		Alerts:     alerts,
+		Suppressed: suppressed,
+	}
+	for _, event := range alerts {
+		if event.State == alert.Resolved {
+			group.Resolved++
+		} else {
+			group.Firing++
+		}
+	}
+
+	group.Title = fmt.Sprintf("[%s] %d firing, %d resolved", group.Host, group.Firing, group.Resolved)
+	if len(alerts) == 1 {
+		group.Title = fmt.Sprintf("[%s] %s %s: %s", group.Host, alerts[0].Rule, alerts[0].State, alerts[0].Description)
+	}
+	return group
+}
+
+// Plain text body shared by the receivers which don't use a template
+func (group *Group) Text() string {
+	text := group.Title + "\n\n"
+	for _, event := range group.Alerts {
+		text += fmt.Sprintf("- %s", event.Rule)
+		if event.Key != "" {
+			text += fmt.Sprintf(" (%s)", event.Key)
+		}
+		text += fmt.Sprintf(" is %s: %s", event.State, event.Description)
+		if event.Summary != "" {
+			text += " - " + event.Summary
+		}
+		text += "\n"
+	}
+	if group.Suppressed > 0 {
+		text += fmt.Sprintf("\n%d events were suppressed by the rate limit since the previous notification\n", group.Suppressed)
+	}
+	return text
+}
@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Maximum time to connect to the SMTP server and send a notification
+const SMTP_TIMEOUT = 30 * time.Second
+
+// SMTP receiver: send the group as a plain text email
+type SmtpConfig struct {
+	Address  string   `json:"address"`            //host:port of the SMTP server
+	Username string   `json:"username,omitempty"` //PLAIN authentication, skipped if empty
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// Check the addresses, they end up in the mail headers so they must be single line
+func (config SmtpConfig) validate() error {
+	if config.Address == "" || config.From == "" || len(config.To) == 0 {
+		return fmt.Errorf("smtp address, from and to are required")
+	}
+	for _, address := range append([]string{config.From}, config.To...) {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid smtp address %q: %w", address, err)
+		}
+	}
+	return nil
+}
+
+type Smtp struct {
+	config SmtpConfig
+}
+
+func NewSmtp(config SmtpConfig) *Smtp {
+	return &Smtp{config: config}
+}
+
+func (mail *Smtp) Send(group *Group) error {
+	host, _, err := net.SplitHostPort(mail.config.Address)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if mail.config.Username != "" {
+		auth = smtp.PlainAuth("", mail.config.Username, mail.config.Password, host)
+	}
+
+	//Build the message, headers are separated from the body by an empty line
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", headerValue(mail.config.From))
+	fmt.Fprintf(&message, "To: %s\r\n", headerValue(strings.Join(mail.config.To, ", ")))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(group.Title)))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(group.Text(), "\n", "\r\n"))
+
+	//Same as smtp.SendMail, with a deadline so a server that never answers cannot block the route forever
+	conn, err := net.DialTimeout("tcp", mail.config.Address, SMTP_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	if err = conn.SetDeadline(time.Now().Add(SMTP_TIMEOUT)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support authentication")
+		}
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(mail.config.From); err != nil {
+		return err
+	}
+	for _, to := range mail.config.To {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write([]byte(message.String())); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Put a value on a single header line, a line break would let it add its own headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"fmt"
+	"log/syslog"
+	"sync"
+	"sys/alert"
+)
+
+// Syslog receiver: write one line per alert to the local (or a remote) syslog
+type SyslogConfig struct {
+	Network string `json:"network,omitempty"` //Empty to use the local syslog, or "udp"/"tcp"
+	Address string `json:"address,omitempty"` //host:port of a remote syslog
+	Tag     string `json:"tag,omitempty"`     //Default "sysmon"
+}
+
+type Syslog struct {
+	sync.Mutex //The receiver can be shared by several routes
+	config     SyslogConfig
+	writer     *syslog.Writer //Connected on the first send, nil until then
+}
+
+/*
+ * The connection is only opened on the first notification, so an unreachable syslog does not prevent
+ * the configuration (and the other receivers) from loading: it fails its own notifications instead
+ */
+func NewSyslog(config SyslogConfig) (*Syslog, error) {
+	if config.Tag == "" {
+		config.Tag = "sysmon"
+	}
+	return &Syslog{config: config}, nil
+}
+
+func (logger *Syslog) Send(group *Group) error {
+	logger.Lock()
+	defer logger.Unlock()
+
+	if logger.writer == nil {
+		writer, err := syslog.Dial(logger.config.Network, logger.config.Address, syslog.LOG_WARNING|syslog.LOG_DAEMON, logger.config.Tag)
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		logger.writer = writer
+	}
+
+	for _, event := range group.Alerts {
+		line := fmt.Sprintf("alert %s", event.Rule)
+		if event.Key != "" {
+			line += fmt.Sprintf(" key=%s", event.Key)
+		}
+		line += fmt.Sprintf(" state=%s severity=%s: %s", event.State, event.Severity, event.Description)
+
+		var err error
+		switch {
+		case event.State == alert.Resolved:
+			err = logger.writer.Notice(line)
+		case event.Severity == "critical":
+			err = logger.writer.Crit(line)
+		default:
+			err = logger.writer.Warning(line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if group.Suppressed > 0 {
+		return logger.writer.Warning(fmt.Sprintf("%d alert events suppressed by the rate limit", group.Suppressed))
+	}
+	return nil
+}
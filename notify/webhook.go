@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sys/alert"
+	"text/template"
+	"time"
+)
+
+const (
+	WEBHOOK_TIMEOUT       = 10 * time.Second
+	WEBHOOK_MAX_RETRIES   = 3
+	WEBHOOK_FIRST_BACKOFF = time.Second //Wait before the first retry, doubled after every attempt
+)
+
+/*
+ * Webhook receiver: POST the group to an URL. Without body template the group is sent as JSON,
+ * otherwise the template is executed with the Group as data. The "json" function quote a value
+ * so it can be embedded safely: {"text": {{ json .Title }}}
+ */
+type WebhookConfig struct {
+	URL        string            `json:"url"`
+	Method     string            `json:"method,omitempty"`      //Default POST
+	Headers    map[string]string `json:"headers,omitempty"`     //Extra headers, ex: Authorization
+	Body       string            `json:"body,omitempty"`        //Body template
+	MaxRetries *int              `json:"max_retries,omitempty"` //Default 3
+	Backoff    alert.Duration    `json:"backoff,omitempty"`     //Wait before the first retry, default 1s
+}
+
+type Webhook struct {
+	config WebhookConfig
+	body   *template.Template
+	client *http.Client
+}
+
+func NewWebhook(config WebhookConfig) (*Webhook, error) {
+	webhook := &Webhook{
+		config: config,
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+	}
+
+	if webhook.config.Method == "" {
+		webhook.config.Method = http.MethodPost
+	}
+	if webhook.config.MaxRetries == nil {
+		retries := WEBHOOK_MAX_RETRIES
+		webhook.config.MaxRetries = &retries
+	}
+	if webhook.config.Backoff <= 0 {
+		webhook.config.Backoff = alert.Duration(WEBHOOK_FIRST_BACKOFF)
+	}
+
+	if config.Body != "" {
+		funcMap := template.FuncMap{
+			"json": func(value any) (string, error) {
+				data, err := json.Marshal(value)
+				return string(data), err
+			},
+		}
+		tmpl, err := template.New("body").Funcs(funcMap).Parse(config.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body template: %w", err)
+		}
+		webhook.body = tmpl
+	}
+
+	return webhook, nil
+}
+
+func (webhook *Webhook) Send(group *Group) error {
+	var (
+		body []byte
+		err  error
+	)
+	if webhook.body == nil {
+		body, err = json.Marshal(group)
+	} else {
+		var buffer bytes.Buffer
+		err = webhook.body.Execute(&buffer, group)
+		body = buffer.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+
+	//Retry with exponential backoff on network errors, 5xx and 429
+	backoff := time.Duration(webhook.config.Backoff)
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = webhook.post(body)
+		if err == nil || !retry || attempt >= *webhook.config.MaxRetries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Send the request once, return whether a failure is worth retrying
+func (webhook *Webhook) post(body []byte) (bool, error) {
+	request, err := http.NewRequest(webhook.config.Method, webhook.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range webhook.config.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := webhook.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook %s returned %s", webhook.config.URL, response.Status)
+}
@@ -0,0 +1,77 @@
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+/*
+ * File system made of a directory on disk on top of a base (usually embedded) file system:
+ * a file of the directory replace the file with the same path in the base, the other files come from the base.
+ * It let users customize some of the web page or the templates without rebuilding the binary
+ */
+type FS struct {
+	dir  fs.FS //nil without override directory
+	base fs.FS
+}
+
+// Put the directory on top of base, an empty dir means base alone. The directory must exist
+func New(dir string, base fs.FS) (*FS, error) {
+	overlay := &FS{base: base}
+	if dir == "" {
+		return overlay, nil
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	overlay.dir = os.DirFS(dir)
+	return overlay, nil
+}
+
+func (overlay *FS) Open(name string) (fs.File, error) {
+	if overlay.dir != nil {
+		file, err := overlay.dir.Open(name)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return file, err
+		}
+	}
+	return overlay.base.Open(name)
+}
+
+// Merge the entries of both file systems, the ones of the directory win
+func (overlay *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(overlay.base, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if overlay.dir == nil {
+		return entries, err
+	}
+
+	overrides, dirErr := fs.ReadDir(overlay.dir, name)
+	if dirErr != nil {
+		if errors.Is(dirErr, fs.ErrNotExist) {
+			return entries, err
+		}
+		return nil, dirErr
+	}
+	for _, override := range overrides {
+		index := slices.IndexFunc(entries, func(entry fs.DirEntry) bool { return entry.Name() == override.Name() })
+		if index >= 0 {
+			entries[index] = override
+		} else {
+			entries = append(entries, override)
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
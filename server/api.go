@@ -0,0 +1,373 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sys/alert"
+	"sys/audit"
+	"sys/auth"
+	"sys/hardware"
+	"sys/history"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+/*
+ * Versioned JSON API. Every endpoint return the latest snapshot collected by the server loop,
+ * the field names and units are documented in the README and must stay stable within a version.
+ */
+
+const API_PREFIX = "/api/v1"
+
+func (server *Server) RegisterAPI() {
+	//Current user and its role
+	server.handle("GET "+API_PREFIX+"/me", auth.Viewer, http.HandlerFunc(server.HandleMeAPI))
+
+	//Health of every collector
+	server.handle("GET "+API_PREFIX+"/collectors", auth.Viewer, http.HandlerFunc(server.HandleCollectorsStatusAPI))
+
+	//History of the samples
+	server.handle("GET "+API_PREFIX+"/query", auth.Viewer, http.HandlerFunc(server.HandleQueryAPI))
+
+	//Alert rules and their current state
+	server.handle("GET "+API_PREFIX+"/alerts", auth.Viewer, http.HandlerFunc(server.HandleAlertsAPI))
+
+	//Trail of the process actions, it contains the command lines so it is reserved to operators
+	server.handle("GET "+API_PREFIX+"/audit", auth.Operator, http.HandlerFunc(server.HandleAuditAPI))
+
+	//Processes filtered, sorted and paginated by the query parameters
+	server.handle("GET "+API_PREFIX+"/processes", auth.Viewer, http.HandlerFunc(server.HandleProcessesAPI))
+
+	//Processes as a tree, with the usage of every subtree
+	server.handle("GET "+API_PREFIX+"/processes/tree", auth.Viewer, http.HandlerFunc(server.HandleProcessTreeAPI))
+
+	//Everything about one process, read when requested
+	server.handle("GET "+API_PREFIX+"/processes/{pid}", auth.Viewer, http.HandlerFunc(server.HandleProcessDetailAPI))
+
+	//Every collector is exposed under its own name (system, cpu, disks, processes, connections and any plugin)
+	server.handle("GET "+API_PREFIX+"/{collector}", auth.Viewer, http.HandlerFunc(server.HandleCollectorAPI))
+}
+
+// Write an already encoded JSON body to the response
+func writeJSON(w http.ResponseWriter, status int, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (server *Server) HandleCollectorAPI(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("collector")
+
+	if server.hw.Collector(name) == nil {
+		http.Error(w, "Unknown collector", http.StatusNotFound)
+		return
+	}
+
+	//Return the last successful collection, the collector status tell if it is stale
+	data := server.hw.Snapshot(name)
+	if data == nil {
+		http.Error(w, "No data collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (server *Server) HandleProcessesAPI(w http.ResponseWriter, r *http.Request) {
+	query, err := parseProcessQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var processes hardware.Processes
+	if !server.hw.Decode(hardware.PROCESS_COLLECTOR, &processes) {
+		http.Error(w, "No data collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	page, total := processes.Apply(query)
+	data, err := json.Marshal(page)
+	if err != nil {
+		slog.Error("Failed to encode processes", "error", err)
+		http.Error(w, "Internal server error: Failed to encode data", http.StatusInternalServerError)
+		return
+	}
+
+	//The page is the body, the number of processes matching the filters is a header
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, data)
+}
+
+// Read a process query from the query parameters (sort, order, name, user, regex, offset, limit)
+func parseProcessQuery(values url.Values) (hardware.ProcessQuery, error) {
+	query := hardware.ProcessQuery{
+		Sort:  values.Get("sort"),
+		Order: values.Get("order"),
+		Name:  values.Get("name"),
+		User:  values.Get("user"),
+		Regex: values.Get("regex"),
+	}
+
+	var err error
+	if offset := values.Get("offset"); offset != "" {
+		if query.Offset, err = strconv.Atoi(offset); err != nil {
+			return query, fmt.Errorf("invalid offset %q", offset)
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return query, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	return query, query.Validate()
+}
+
+func (server *Server) HandleProcessTreeAPI(w http.ResponseWriter, r *http.Request) {
+	var processes hardware.Processes
+	if !server.hw.Decode(hardware.PROCESS_COLLECTOR, &processes) {
+		http.Error(w, "No data collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	data, err := json.Marshal(processes.Tree())
+	if err != nil {
+		slog.Error("Failed to encode process tree", "error", err)
+		http.Error(w, "Internal server error: Failed to encode data", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (server *Server) HandleProcessDetailAPI(w http.ResponseWriter, r *http.Request) {
+	pid, err := strconv.ParseInt(r.PathValue("pid"), 10, 32)
+	if err != nil || pid <= 0 {
+		http.Error(w, "Invalid PID", http.StatusBadRequest)
+		return
+	}
+
+	//The environment often contains secrets (tokens, passwords), only operators can read it
+	withEnv := r.URL.Query().Get("env") == "true"
+	if withEnv && !auth.UserFromContext(r.Context()).Role.Allows(auth.Operator) {
+		http.Error(w, "Forbidden: the environment is reserved to operators", http.StatusForbidden)
+		return
+	}
+
+	detail, err := hardware.GetProcessDetail(int32(pid), withEnv)
+	if errors.Is(err, process.ErrorProcessNotRunning) {
+		http.Error(w, "Process not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to read process detail", "pid", pid, "error", err)
+		http.Error(w, "Internal server error: Failed to read process", http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(detail)
+	if err != nil {
+		slog.Error("Failed to encode process detail", "pid", pid, "error", err)
+		http.Error(w, "Internal server error: Failed to encode data", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (server *Server) HandleCollectorsStatusAPI(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(server.hw.Status())
+	if err != nil {
+		slog.Error("Failed to encode collectors status", "error", err)
+		http.Error(w, "Internal server error: Failed to encode data", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+/*
+ * Parse a time parameter of the query API. Accepted formats:
+ * RFC3339 ("2025-01-02T15:04:05Z"), unix seconds ("1735830245") or a duration relative to now ("-5m")
+ */
+func parseQueryTime(value string, now time.Time, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(duration), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+/*
+ * Query the history: /api/v1/query?metric=cpu.usage&from=-5m&to=now&step=10s
+ * Without metric, return the list of available metrics (optionally filtered with prefix=)
+ * The response contain one bucket per step with the min, max and average of the raw points
+ */
+func (server *Server) HandleQueryAPI(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	metric := params.Get("metric")
+
+	//List the metrics
+	if metric == "" {
+		data, err := json.Marshal(server.history.Names(params.Get("prefix")))
+		if err != nil {
+			slog.Error("Failed to encode metric names", "error", err)
+			http.Error(w, "Internal server error: Failed to encode data", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, data)
+		return
+	}
+
+	//Parse the time range, default to the last 5 minutes
+	now := time.Now()
+	from, err := parseQueryTime(params.Get("from"), now, now.Add(-5*time.Minute))
+	if err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	toRaw := params.Get("to")
+	if toRaw == "now" {
+		toRaw = ""
+	}
+	to, err := parseQueryTime(toRaw, now, now)
+	if err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var step time.Duration
+	if stepRaw := params.Get("step"); stepRaw != "" {
+		step, err = time.ParseDuration(stepRaw)
+		if err != nil || step < 0 {
+			http.Error(w, "Invalid step", http.StatusBadRequest)
+			return
+		}
+	}
+
+	//Recent ranges are answered from memory, older ones from the persistent storage
+	var (
+		buckets []history.Bucket
+		ok      bool
+	)
+	if server.storage != nil && from.Before(now.Add(-server.history.Retention())) {
+		buckets, ok = server.storage.Query(metric, from, to, step)
+	} else {
+		buckets, ok = server.history.Query(metric, from, to, step)
+	}
+	if !ok {
+		http.Error(w, "Unknown metric", http.StatusNotFound)
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		Metric string           `json:"metric"`
+		From   time.Time        `json:"from"`
+		To     time.Time        `json:"to"`
+		Step   string           `json:"step"`
+		Points []history.Bucket `json:"points"`
+	}{
+		Metric: metric,
+		From:   from,
+		To:     to,
+		Step:   step.String(),
+		Points: buckets,
+	})
+	if err != nil {
+		slog.Error("Failed to encode query result", "error", err)
+		http.Error(w, "Internal server error: Failed to encode data", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (server *Server) HandleAlertsAPI(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(struct {
+		Rules  []alert.Rule  `json:"rules"`
+		Alerts []alert.Alert `json:"alerts"`
+	}{
+		Rules:  server.alerts.Rules(),
+		Alerts: server.alerts.Alerts(),
+	})
+	if err != nil {
+		slog.Error("Failed to encode alerts", "error", err)
+		http.Error(w, "Internal server error: Failed to encode data", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+/*
+ * Query the audit log: /api/v1/audit?from=-24h&to=now&user=admin&action=kill&pid=1234&limit=100
+ * Every parameter is optional, the entries are returned oldest first (the last 100 by default)
+ */
+func (server *Server) HandleAuditAPI(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	filter := audit.Filter{
+		User:   params.Get("user"),
+		Action: params.Get("action"),
+		Limit:  100,
+	}
+
+	now := time.Now()
+	var err error
+	if filter.From, err = parseQueryTime(params.Get("from"), now, time.Time{}); err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	toRaw := params.Get("to")
+	if toRaw == "now" {
+		toRaw = ""
+	}
+	if filter.To, err = parseQueryTime(toRaw, now, time.Time{}); err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if pidRaw := params.Get("pid"); pidRaw != "" {
+		pid, err := strconv.ParseInt(pidRaw, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid pid", http.StatusBadRequest)
+			return
+		}
+		filter.PID = int32(pid)
+	}
+	if limitRaw := params.Get("limit"); limitRaw != "" {
+		filter.Limit, err = strconv.Atoi(limitRaw)
+		if err != nil || filter.Limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := server.audit.Query(filter)
+	if err != nil {
+		slog.Error("Failed to read the audit log", "error", err)
+		http.Error(w, "Internal server error: Failed to read the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		slog.Error("Failed to encode audit entries", "error", err)
+		http.Error(w, "Internal server error: Failed to encode data", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sys/auth"
+)
+
+// Register a handler reachable only by the users having at least the given role
+func (server *Server) handle(pattern string, role auth.Role, handler http.Handler) {
+	server.mux.Handle(pattern, server.auth.Require(role, handler))
+}
+
+/*
+ * Only accept the websocket connections opened by the dashboard itself (or an allowed origin),
+ * otherwise any page visited by a logged in user could read the data with the user's cookie.
+ * Clients without Origin header are not browsers, they have to use an API token
+ */
+func (server *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	return slices.Contains(server.config.Auth.AllowedOrigins, origin)
+}
+
+func (server *Server) HandleLoginPage(w http.ResponseWriter, r *http.Request) {
+	//Nothing to log in to
+	if server.auth == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	http.ServeFileFS(w, r, server.web, "login.html")
+}
+
+func (server *Server) HandleMeAPI(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	data, err := json.Marshal(map[string]any{
+		"name":         user.Name,
+		"role":         user.Role,
+		"auth_enabled": server.auth != nil,
+	})
+	if err != nil {
+		http.Error(w, "Failed to encode user", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, data)
+}
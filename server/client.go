@@ -1,72 +1,198 @@
 package server
 
 import (
-	"fmt"
+	"log/slog"
 	"sync"
+	"sys/hardware"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const WRITE_TIMEOUT = 10 * time.Second //Maximum time allowed to write a message to a client
+
+// Policy applied when a client's queue is full
+type SlowClientPolicy int
+
+const (
+	DropOldest SlowClientPolicy = iota //Discard the oldest pending message to make room for the new one
+	Disconnect                         //Close the connection of the client that cannot keep up
+)
+
 type Client struct {
-	sync.Mutex                 //Embedding mutex to avoid race condition (can also use a mutex variable here)
-	server     *Server         //The server pointer
-	msgs       chan []byte     //Message channel for each client
-	conn       *websocket.Conn //The client connection struct
+	sync.Mutex                       //Embedding mutex to avoid race condition (can also use a mutex variable here)
+	server     *Server               //The server pointer
+	msgs       chan []byte           //Message channel for each client (bounded queue)
+	conn       *websocket.Conn       //The client connection struct
+	dropped    uint64                //Number of messages dropped because the client is too slow
+	sections   map[string]bool       //Subscribed sections, nil for all of them
+	interval   time.Duration         //Minimum time between two updates
+	lastSent   time.Time             //When the last update has been queued
+	format     string                //FORMAT_HTML or FORMAT_JSON
+	tables     map[string]sentTable  //Rows already sent of the table sections
+	query      hardware.ProcessQuery //Processes to show and their order, the zero query for the default view
+}
+
+// Remote address of the client, used in the logs
+func (client *Client) Addr() string {
+	return client.conn.RemoteAddr().String()
 }
 
 func NewClient(conn *websocket.Conn, server *Server) *Client {
 	return &Client{
-		server: server,
-		msgs:   make(chan []byte),
-		conn:   conn,
+		server:   server,
+		msgs:     make(chan []byte, server.config.Limits.ClientQueue),
+		conn:     conn,
+		interval: server.config.Interval,
+		format:   FORMAT_HTML,
+		tables:   make(map[string]sentTable),
 	}
 }
 
-func (client *Client) SendMessages() {
-	//If the loop is broken from (which means the connection is off for some reason), we want to remove the client
-	defer client.server.RemoveClient(client)
+// Change the sections (nil to keep the current ones), the refresh interval and the format (empty to keep it) of the client
+func (client *Client) Subscribe(sections []string, interval time.Duration, format string) {
+	client.Lock()
+	defer client.Unlock()
+
+	if format != "" && format != client.format {
+		//The rows sent in the other format are useless
+		client.format = format
+		client.tables = make(map[string]sentTable)
+	}
+	if sections != nil {
+		client.sections = make(map[string]bool, len(sections))
+		for _, section := range sections {
+			client.sections[section] = true
+		}
+	}
+	client.interval = interval
+}
+
+// Change the processes the client receive, the next update send them whole
+func (client *Client) SetQuery(query hardware.ProcessQuery) {
+	client.Lock()
+	defer client.Unlock()
+
+	client.query = query
+	delete(client.tables, hardware.PROCESS_COLLECTOR)
+}
+
+func (client *Client) Query() hardware.ProcessQuery {
+	client.Lock()
+	defer client.Unlock()
+
+	return client.query
+}
+
+// Whether the client is subscribed to the section
+func (client *Client) Wants(section string) bool {
+	client.Lock()
+	defer client.Unlock()
+
+	return client.wants(section)
+}
+
+// Same as Wants, the client must be locked
+func (client *Client) wants(section string) bool {
+	return client.sections == nil || client.sections[section]
+}
+
+func (client *Client) Format() string {
+	client.Lock()
+	defer client.Unlock()
+
+	return client.format
+}
+
+/*
+ * Whether the client should receive the update collected at now, and mark it as sent.
+ * Ticks are not perfectly regular, so half a tick of tolerance is allowed
+ */
+func (client *Client) due(now time.Time, tick time.Duration) bool {
+	client.Lock()
+	defer client.Unlock()
 
-	//Continously reading messages from Broadcast channel and write the data to client
+	if now.Sub(client.lastSent) < client.interval-tick/2 {
+		return false
+	}
+	client.lastSent = now
+	return true
+}
+
+// Read the messages of the client until the connection is closed
+func (client *Client) ReadMessages() {
+	client.conn.SetReadLimit(MAX_MESSAGE_SIZE)
 	for {
-		select {
-		//If we receive the message from broadcast channel, then we send the message to client
-		case msg, ok := <-Broadcast:
-			//If fail to read message from broadcast (channel unexpectedly closing,...), send error message to clients
-			if !ok {
-				fmt.Println("Failed to read messages from broadcast channel")
-				err := client.conn.WriteMessage(websocket.CloseMessage, nil)
-				if err != nil {
-					fmt.Println("Error sending error message to client")
-					fmt.Printf("Error: %v\n", err)
-				}
-				return
+		msgType, data, err := client.conn.ReadMessage()
+		if err != nil {
+			//Closed by the client, or by us
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Debug("Failed to read message from client", "client", client.Addr(), "error", err)
 			}
+			client.server.RemoveClient(client)
+			return
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
 
-			//Send message to client
-			err := client.conn.WriteMessage(websocket.TextMessage, msg)
-			if err != nil {
-				fmt.Println("Failed to send message to clients")
-				fmt.Printf("Error: %v\n", err)
-			}
+		if err := client.server.handleMessage(client, data); err != nil {
+			slog.Warn("Invalid message from client", "client", client.Addr(), "error", err)
+		}
+	}
+}
 
-		//If we receive message from individual client's message channel, then we process the request and only response
-		//to the specific client
-		case msg, ok := <-client.msgs:
-			if !ok {
-				fmt.Println("Failed to read messages")
-				err := client.conn.WriteMessage(websocket.CloseMessage, nil)
-				if err != nil {
-					fmt.Println("Error sending error message to client")
-					fmt.Printf("Error: %v\n", err)
-				}
-				return
-			}
+// Push a message to the client's queue without blocking. Return false if the client cannot keep up
+// and should be disconnected (only possible with the Disconnect policy)
+func (client *Client) Enqueue(msg []byte, policy SlowClientPolicy) bool {
+	for {
+		select {
+		case client.msgs <- msg:
+			return true
+		default:
+		}
 
-			//Send message to client
-			err := client.conn.WriteMessage(websocket.TextMessage, msg)
-			if err != nil {
-				fmt.Println("Failed to write message to clients")
-			}
+		//The queue is full, apply the slow consumer policy
+		if policy == Disconnect {
+			return false
 		}
+
+		//Drop the oldest message then try again. The next updates are computed against rows the client never
+		//received, so its tables are forgotten and the next update send them whole
+		select {
+		case <-client.msgs:
+			client.Lock()
+			client.dropped++
+			client.tables = make(map[string]sentTable)
+			client.Unlock()
+		default:
+		}
+	}
+}
+
+func (client *Client) SendMessages() {
+	defer client.server.writers.Done()
+
+	//Continously reading messages from the client's queue (filled by the server broadcast) and write the data to client
+	for msg := range client.msgs {
+		//Send message to client
+		client.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+		err := client.conn.WriteMessage(websocket.TextMessage, msg)
+		if err != nil {
+			//If the write fail (which means the connection is off for some reason), we want to remove the client
+			slog.Warn("Failed to send message to client", "client", client.Addr(), "error", err)
+			client.server.RemoveClient(client)
+			client.conn.Close()
+			return
+		}
+	}
+
+	//The queue has been closed (shutdown or the client left), say goodbye before closing the connection
+	client.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	err := client.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+	if err != nil {
+		//Expected when the client closed the connection first
+		slog.Debug("Failed to send close message to client", "client", client.Addr(), "error", err)
 	}
+	client.conn.Close()
 }
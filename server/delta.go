@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"slices"
+	"strings"
+	"sys/hardware"
+	"time"
+)
+
+/*
+ * Table sections (processes, connections) are sent as deltas: every client remember the rows it received,
+ * and only the added, removed and changed rows are sent on the next update. In HTML, the rows are swapped
+ * out of band by id. In JSON, the changes are a JSON patch (RFC 6902) of the object of the rows by key.
+ * The whole table is still sent when the client has nothing to diff with, when most rows changed,
+ * and every FULL_RESYNC_INTERVAL in HTML, since the new rows are appended (or inserted under the row they belong to)
+ * and the table drift from its sort order.
+ * A client with a process query (see hardware.ProcessQuery) receive the view of its query instead of the default one.
+ * Its order matters, so in HTML the whole view is sent when the order changed, and in JSON every update carry the order
+ */
+
+const FULL_RESYNC_INTERVAL = 30 * time.Second
+
+// Format of the messages sent to a client
+const (
+	FORMAT_HTML = "html"
+	FORMAT_JSON = "json"
+)
+
+// Kind of a section update in the JSON format
+const (
+	SNAPSHOT_UPDATE = "snapshot"
+	PATCH_UPDATE    = "patch"
+)
+
+// Update of a section in the JSON format, a message is an array of them
+type jsonUpdate struct {
+	Section string           `json:"section"`
+	Type    string           `json:"type"`
+	Data    json.RawMessage  `json:"data,omitempty"`  //The whole section, for a snapshot
+	Patch   []patchOperation `json:"patch,omitempty"` //Changes since the previous update, for a patch
+	Order   []string         `json:"order,omitempty"` //Keys of the rows in display order, with a process query
+	Total   *int             `json:"total,omitempty"` //Rows matching the filters before the pagination, with a process query
+}
+
+// JSON patch operation (RFC 6902)
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Rows of a table section the client already has
+type sentTable struct {
+	rows    map[string]hardware.Row //Shared with the frame, never modified
+	full    time.Time               //When the whole table has been sent
+	keys    []string                //Keys of the rows in display order, for a process query
+	summary string                  //Summary of the process query view
+}
+
+/*
+ * Build the update of a client from a frame, with the given sections (the subscribed ones if nil).
+ * full send whole sections even for the tables the client already has
+ */
+func (client *Client) update(frame *frame, sections []string, full bool) []byte {
+	client.Lock()
+	defer client.Unlock()
+
+	var updates []jsonUpdate
+	var buffer bytes.Buffer
+	for _, section := range frame.sections {
+		if sections == nil && !client.wants(section) || sections != nil && !slices.Contains(sections, section) {
+			continue
+		}
+
+		fragment, data, index := frame.fragments[section], frame.data[section], frame.index[section]
+		rows, table := frame.rows[section]
+		var view *tableView
+		if section == hardware.PROCESS_COLLECTOR && !client.query.IsZero() {
+			if view = frame.processView(client.query); view != nil {
+				fragment, data, rows, index = view.fragment, view.data, view.rows, view.index
+			}
+		}
+
+		previous, sent := client.tables[section]
+		send := full || !table || !sent
+		if !send && client.format == FORMAT_HTML && frame.time.Sub(previous.full) >= FULL_RESYNC_INTERVAL {
+			send = true
+		}
+		var keys []string
+		reordered := false
+		if view != nil {
+			keys = rowKeys(rows)
+			reordered = !slices.Equal(keys, previous.keys) || view.summary != previous.summary
+		}
+
+		var diff hardware.TableDiff
+		if !send {
+			same := sameCells
+			if client.format == FORMAT_JSON {
+				same = sameJson
+			}
+			diff = hardware.DiffRows(previous.rows, rows, same)
+			if diff.Empty() && !reordered {
+				continue
+			}
+			//Past a point the delta is as big as the table. A view whose order changed is sent whole in HTML
+			send = len(diff.Added)+len(diff.Changed) > len(rows)/2 || reordered && client.format == FORMAT_HTML
+		}
+
+		var update jsonUpdate
+		switch {
+		case send && client.format == FORMAT_JSON:
+			if data != nil {
+				update = jsonUpdate{Section: section, Type: SNAPSHOT_UPDATE, Data: data}
+			}
+		case send:
+			buffer.Write(fragment)
+		case client.format == FORMAT_JSON:
+			update = jsonUpdate{Section: section, Type: PATCH_UPDATE, Patch: jsonPatch(diff)}
+		default:
+			writeRowsDelta(&buffer, section, diff)
+		}
+		if update.Section != "" {
+			if view != nil {
+				update.Order, update.Total = keys, &view.total
+			}
+			updates = append(updates, update)
+		}
+
+		if table {
+			if send {
+				previous.full = frame.time
+			}
+			previous.rows = index
+			if view != nil {
+				previous.keys, previous.summary = keys, view.summary
+			} else {
+				previous.keys, previous.summary = nil, ""
+			}
+			client.tables[section] = previous
+		}
+	}
+
+	if client.format == FORMAT_JSON {
+		if len(updates) == 0 {
+			return nil
+		}
+		data, err := json.Marshal(updates)
+		if err != nil {
+			slog.Error("Failed to encode update", "client", client.Addr(), "error", err)
+			return nil
+		}
+		return data
+	}
+	return buffer.Bytes()
+}
+
+// Forget the rows sent to the client, so the next update send whole tables (ex: after a new layout)
+func (client *Client) resetTables() {
+	client.Lock()
+	defer client.Unlock()
+
+	client.tables = make(map[string]sentTable)
+}
+
+func rowKeys(rows []hardware.Row) []string {
+	keys := make([]string, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, row.Key)
+	}
+	return keys
+}
+
+func sameCells(old, new hardware.Row) bool {
+	return old.Cells == new.Cells
+}
+
+func sameJson(old, new hardware.Row) bool {
+	return bytes.Equal(old.Json, new.Json)
+}
+
+// Out of band swaps applying a diff to the table of the page
+func writeRowsDelta(buffer *bytes.Buffer, section string, diff hardware.TableDiff) {
+	for _, row := range diff.Removed {
+		fmt.Fprintf(buffer, `<tr id="%s" hx-swap-oob="delete"></tr>`, template.HTMLEscapeString(row.ID))
+	}
+	for _, row := range diff.Changed {
+		fmt.Fprintf(buffer, `<tr id="%s" hx-swap-oob="true">%s</tr>`, template.HTMLEscapeString(row.ID), row.Cells)
+	}
+	//The content of the element is inserted at the target: appended to the table, or right after the row
+	//it belongs under (ex: a new process under its parent). The rows are in display order, so that row is already there
+	for _, row := range diff.Added {
+		target := "beforeend:#" + hardware.RowsID(section)
+		if row.Above != "" {
+			target = "afterend:#" + row.Above
+		}
+		fmt.Fprintf(buffer, `<tbody hx-swap-oob="%s"><tr id="%s">%s</tr></tbody>`,
+			template.HTMLEscapeString(target), template.HTMLEscapeString(row.ID), row.Cells)
+	}
+}
+
+// JSON patch applying a diff to the object of the rows by key
+func jsonPatch(diff hardware.TableDiff) []patchOperation {
+	operations := make([]patchOperation, 0, len(diff.Removed)+len(diff.Added)+len(diff.Changed))
+	for _, row := range diff.Removed {
+		operations = append(operations, patchOperation{Op: "remove", Path: pointer(row.Key)})
+	}
+	for _, row := range diff.Added {
+		operations = append(operations, patchOperation{Op: "add", Path: pointer(row.Key), Value: row.Json})
+	}
+	for _, row := range diff.Changed {
+		operations = append(operations, patchOperation{Op: "replace", Path: pointer(row.Key), Value: row.Json})
+	}
+	return operations
+}
+
+// JSON pointer (RFC 6901) of a row
+func pointer(key string) string {
+	return "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// Object of the rows by key, the JSON snapshot of a table section
+func rowsObject(rows []hardware.Row) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, row := range rows {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(row.Key)
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(row.Json)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes()
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"sys/hardware"
+	"testing"
+	"time"
+)
+
+// Frame with a single table section of n rows, the row of key changed has a different value
+func tableFrame(now time.Time, n int, changed string) *frame {
+	var rows []hardware.Row
+	for i := range n {
+		key := fmt.Sprint(i)
+		value := "0"
+		if key == changed {
+			value = now.Format(time.RFC3339Nano)
+		}
+		rows = append(rows, hardware.Row{
+			Key:   key,
+			ID:    "row-" + key,
+			Cells: template.HTML("<td>" + value + "</td>"),
+			Json:  []byte(fmt.Sprintf(`{"value":%q}`, value)),
+		})
+	}
+	return &frame{
+		time:      now,
+		sections:  []string{hardware.NET_COLLECTOR},
+		fragments: map[string][]byte{hardware.NET_COLLECTOR: []byte("<table></table>")},
+		data:      map[string][]byte{hardware.NET_COLLECTOR: rowsObject(rows)},
+		rows:      map[string][]hardware.Row{hardware.NET_COLLECTOR: rows},
+		index:     map[string]map[string]hardware.Row{hardware.NET_COLLECTOR: hardware.IndexRows(rows)},
+	}
+}
+
+// Type of the update of the table section in a JSON message
+func updateType(t *testing.T, message []byte) string {
+	t.Helper()
+	var updates []jsonUpdate
+	if err := json.Unmarshal(message, &updates); err != nil {
+		t.Fatalf("invalid update %s: %v", message, err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("got %d updates, want 1: %s", len(updates), message)
+	}
+	return updates[0].Type
+}
+
+func TestDroppedMessageResendTables(t *testing.T) {
+	client := &Client{
+		msgs:   make(chan []byte, 1),
+		format: FORMAT_JSON,
+		tables: make(map[string]sentTable),
+	}
+	now := time.Unix(1700000000, 0)
+
+	//First update: the client has nothing, the table is sent whole
+	first := client.update(tableFrame(now, 10, ""), nil, false)
+	if typ := updateType(t, first); typ != SNAPSHOT_UPDATE {
+		t.Fatalf("first update is a %s, want a snapshot", typ)
+	}
+	if !client.Enqueue(first, DropOldest) {
+		t.Fatal("Enqueue refused the first message")
+	}
+
+	//One row changed: a patch, queued while the first message is still waiting, which drops it
+	second := client.update(tableFrame(now.Add(time.Second), 10, "3"), nil, false)
+	if typ := updateType(t, second); typ != PATCH_UPDATE {
+		t.Fatalf("second update is a %s, want a patch", typ)
+	}
+	if !client.Enqueue(second, DropOldest) {
+		t.Fatal("Enqueue refused the second message")
+	}
+	if client.dropped != 1 {
+		t.Fatalf("dropped = %d, want 1", client.dropped)
+	}
+
+	//The client never got the snapshot the patches are based on, the next update must be one
+	third := client.update(tableFrame(now.Add(2*time.Second), 10, "4"), nil, false)
+	if typ := updateType(t, third); typ != SNAPSHOT_UPDATE {
+		t.Errorf("update after a dropped message is a %s, want a snapshot", typ)
+	}
+
+	//Then the deltas resume
+	fourth := client.update(tableFrame(now.Add(3*time.Second), 10, "5"), nil, false)
+	if typ := updateType(t, fourth); typ != PATCH_UPDATE {
+		t.Errorf("update after the snapshot is a %s, want a patch", typ)
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sys/hardware"
+)
+
+/*
+ * Prometheus exporter: expose the latest snapshot in the text exposition format
+ * (https://prometheus.io/docs/instrumenting/exposition_formats/)
+ * Every metric is prefixed with "sysmon_" to avoid clashing with the official node exporter
+ */
+
+const METRICS_PREFIX = "sysmon_"
+
+// Small helper used to write metric families into a buffer
+type metricsWriter struct {
+	buffer bytes.Buffer
+}
+
+// Write the HELP and TYPE header of a metric family
+func (writer *metricsWriter) family(name, metricType, help string) {
+	fmt.Fprintf(&writer.buffer, "# HELP %s%s %s\n", METRICS_PREFIX, name, help)
+	fmt.Fprintf(&writer.buffer, "# TYPE %s%s %s\n", METRICS_PREFIX, name, metricType)
+}
+
+// Write one sample. Labels are given as key-value pairs
+func (writer *metricsWriter) sample(name string, value float64, labels ...string) {
+	writer.buffer.WriteString(METRICS_PREFIX + name)
+	if len(labels) > 0 {
+		writer.buffer.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				writer.buffer.WriteByte(',')
+			}
+			fmt.Fprintf(&writer.buffer, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		writer.buffer.WriteByte('}')
+	}
+	fmt.Fprintf(&writer.buffer, " %g\n", value)
+}
+
+// Escape the label value as required by the exposition format
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return value
+}
+
+// Render the whole hardware snapshot into the exposition format.
+// Cardinality cap: only the maxProcesses processes using the most CPU are exported
+func WriteMetrics(hw *hardware.Hardware, maxProcesses int) []byte {
+	writer := &metricsWriter{}
+
+	//Work on a copy of the last successful collections, so we never read data being collected
+	var (
+		sysInfo     hardware.SystemInfo
+		cpuInfo     hardware.CpuInfo
+		diskInfo    hardware.DiskInfo
+		processInfo hardware.Processes
+		netInfo     hardware.Connections
+	)
+	//A collector which is disabled or never succeeded has no metric, rather than zeros looking like real readings
+	hasSys := hw.Decode(hardware.SYSTEM_COLLECTOR, &sysInfo)
+	hasCpu := hw.Decode(hardware.CPU_COLLECTOR, &cpuInfo)
+	hasDisk := hw.Decode(hardware.DISK_COLLECTOR, &diskInfo)
+	hasProcesses := hw.Decode(hardware.PROCESS_COLLECTOR, &processInfo)
+	hasNet := hw.Decode(hardware.NET_COLLECTOR, &netInfo)
+
+	//Collectors health
+	status := hw.Status()
+	writer.family("collector_up", "gauge", "Whether the last collection of the collector succeeded (1) or not (0).")
+	for _, collector := range status {
+		up := 0.0
+		if collector.Healthy {
+			up = 1
+		}
+		writer.sample("collector_up", up, "collector", collector.Name)
+	}
+
+	writer.family("collector_last_success_timestamp_seconds", "gauge", "Unix time of the last successful collection, 0 if it never succeeded.")
+	for _, collector := range status {
+		lastSuccess := 0.0
+		if !collector.LastSuccess.IsZero() {
+			lastSuccess = float64(collector.LastSuccess.Unix())
+		}
+		writer.sample("collector_last_success_timestamp_seconds", lastSuccess, "collector", collector.Name)
+	}
+
+	//CPU
+	if hasCpu {
+		writer.family("cpu_usage_percent", "gauge", "Total CPU usage in percent.")
+		writer.sample("cpu_usage_percent", cpuInfo.TotalUsage)
+
+		writer.family("cpu_mode_percent", "gauge", "Share of the total CPU time spent in each mode, in percent.")
+		writer.sample("cpu_mode_percent", cpuInfo.Breakdown.User, "mode", "user")
+		writer.sample("cpu_mode_percent", cpuInfo.Breakdown.System, "mode", "system")
+		writer.sample("cpu_mode_percent", cpuInfo.Breakdown.Iowait, "mode", "iowait")
+		writer.sample("cpu_mode_percent", cpuInfo.Breakdown.Irq, "mode", "irq")
+		writer.sample("cpu_mode_percent", cpuInfo.Breakdown.Softirq, "mode", "softirq")
+		writer.sample("cpu_mode_percent", cpuInfo.Breakdown.Steal, "mode", "steal")
+		writer.sample("cpu_mode_percent", cpuInfo.Breakdown.Idle, "mode", "idle")
+
+		writer.family("cpu_core_usage_percent", "gauge", "CPU usage per core in percent.")
+		for core, usage := range cpuInfo.UsagePerCores {
+			writer.sample("cpu_core_usage_percent", usage, "core", fmt.Sprint(core))
+		}
+
+		writer.family("load_average", "gauge", "System load average.")
+		writer.sample("load_average", cpuInfo.Load1, "period", "1m")
+		writer.sample("load_average", cpuInfo.Load5, "period", "5m")
+		writer.sample("load_average", cpuInfo.Load15, "period", "15m")
+	}
+
+	//Memory
+	if hasSys {
+		writer.family("memory_total_bytes", "gauge", "Total RAM in bytes.")
+		writer.sample("memory_total_bytes", float64(sysInfo.TotalVM))
+
+		writer.family("memory_used_bytes", "gauge", "Used RAM in bytes.")
+		writer.sample("memory_used_bytes", float64(sysInfo.UsedVM))
+	}
+
+	//Disk
+	if hasDisk {
+		writer.family("partition_total_bytes", "gauge", "Total size of the partition in bytes.")
+		for _, partition := range diskInfo {
+			writer.sample("partition_total_bytes", float64(partition.Total), "device", partition.DeviceName, "mountpoint", partition.Mountpoint)
+		}
+
+		writer.family("partition_free_bytes", "gauge", "Free space of the partition in bytes.")
+		for _, partition := range diskInfo {
+			writer.sample("partition_free_bytes", float64(partition.Free), "device", partition.DeviceName, "mountpoint", partition.Mountpoint)
+		}
+	}
+
+	//Processes (capped to avoid exploding the number of series)
+	if hasProcesses {
+		processes := processInfo
+		if len(processes) > maxProcesses {
+			processes = processes[:maxProcesses]
+		}
+
+		writer.family("processes", "gauge", "Number of running processes.")
+		writer.sample("processes", float64(len(processInfo)))
+
+		writer.family("process_cpu_percent", "gauge", "CPU usage of the process in percent.")
+		for _, proc := range processes {
+			writer.sample("process_cpu_percent", proc.CpuUsagePercent, "pid", fmt.Sprint(proc.PID), "name", proc.Name)
+		}
+
+		writer.family("process_resident_memory_bytes", "gauge", "Resident set size of the process in bytes.")
+		for _, proc := range processes {
+			writer.sample("process_resident_memory_bytes", float64(proc.MemoryUsed), "pid", fmt.Sprint(proc.PID), "name", proc.Name)
+		}
+
+		writer.family("process_threads", "gauge", "Number of threads used by the process.")
+		for _, proc := range processes {
+			writer.sample("process_threads", float64(proc.NumberOfThreadUsed), "pid", fmt.Sprint(proc.PID), "name", proc.Name)
+		}
+
+		writer.family("process_read_bytes_total", "counter", "Bytes read from storage by the process.")
+		for _, proc := range processes {
+			writer.sample("process_read_bytes_total", float64(proc.ReadBytes), "pid", fmt.Sprint(proc.PID), "name", proc.Name)
+		}
+
+		writer.family("process_written_bytes_total", "counter", "Bytes written to storage by the process.")
+		for _, proc := range processes {
+			writer.sample("process_written_bytes_total", float64(proc.WriteBytes), "pid", fmt.Sprint(proc.PID), "name", proc.Name)
+		}
+	}
+
+	//Connections, grouped by state (sorted so the output is stable between scrapes)
+	if hasNet {
+		counts := make(map[string]int)
+		for _, conn := range netInfo {
+			status := conn.Status
+			if status == "" {
+				status = "NONE"
+			}
+			counts[status]++
+		}
+		states := make([]string, 0, len(counts))
+		for state := range counts {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+
+		writer.family("connections", "gauge", "Number of network connections by state.")
+		for _, state := range states {
+			writer.sample("connections", float64(counts[state]), "state", state)
+		}
+	}
+
+	return writer.buffer.Bytes()
+}
+
+func (server *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	data := WriteMetrics(server.hw, server.config.Limits.MaxProcessSeries)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"sys/hardware"
+	"time"
+)
+
+/*
+ * Websocket protocol. By default the server push HTML fragments swapped out of band by HTMX:
+ * the page layout (#main) when a client connects or change its sections, then on every refresh
+ * one fragment per subscribed section ("section-<name>", alerts in #alerts). The table sections
+ * only send their changed rows once the client has the table (see delta.go).
+ * With the JSON format (?format=json when connecting, or a subscribe message), there is no layout and every message
+ * is an array of section updates: {"section": "cpu", "type": "snapshot", "data": {...}} with the whole section,
+ * or {"section": "processes", "type": "patch", "patch": [{"op": "remove", "path": "/1234"}, ...]}.
+ * The client can send JSON messages:
+ *
+ *	{"type": "subscribe", "sections": ["system", "cpu"], "interval": "5s", "format": "json"}
+ *		Only receive these sections (all of them if sections is omitted), at most every interval
+ *		(the collection interval if omitted or shorter), in this format ("html" or "json"). Every field is optional.
+ *	{"type": "refresh", "sections": ["processes"]}
+ *		Send the latest data of these sections now, tables included (the subscribed ones if omitted)
+ *	{"type": "processes", "sort": "cpu", "order": "desc", "name": "", "user": "", "regex": "", "offset": 0, "limit": 50}
+ *		Show only the processes matching the filters, sorted by a key, one page at a time (see hardware.ProcessQuery).
+ *		Every field is optional, a message without any of them goes back to the default view (the tree on the dashboard)
+ */
+
+const (
+	ALERTS_SECTION   = "alerts" //Section of the alerts, outside of the layout
+	MAX_MESSAGE_SIZE = 4096     //Maximum size of a message sent by a client
+)
+
+const (
+	SUBSCRIBE_MESSAGE = "subscribe"
+	REFRESH_MESSAGE   = "refresh"
+	PROCESSES_MESSAGE = "processes"
+)
+
+type ClientMessage struct {
+	Type     string      `json:"type"`
+	Sections sectionList `json:"sections"` //nil if omitted
+	Interval string      `json:"interval"` //Go duration, ex: "5s"
+	Format   string      `json:"format"`   //FORMAT_HTML or FORMAT_JSON, empty to keep the current one
+
+	//Process query, see hardware.ProcessQuery
+	Sort   string      `json:"sort"`
+	Order  string      `json:"order"`
+	Name   string      `json:"name"`
+	User   string      `json:"user"`
+	Regex  string      `json:"regex"`
+	Offset numberField `json:"offset"`
+	Limit  numberField `json:"limit"`
+}
+
+// Integer also accepted as a string (an empty one for 0), since HTMX send the form fields as strings
+type numberField int
+
+func (number *numberField) UnmarshalJSON(data []byte) error {
+	var value int
+	if err := json.Unmarshal(data, &value); err != nil {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return errors.New("expected a number")
+		}
+		if text != "" {
+			if value, err = strconv.Atoi(text); err != nil {
+				return fmt.Errorf("invalid number %q", text)
+			}
+		}
+	}
+	*number = numberField(value)
+	return nil
+}
+
+/*
+ * List of section names, also accepted as a single string since HTMX send a form field with one value as a string.
+ * Empty names are dropped, so a form can send an empty hidden field to unsubscribe from everything
+ */
+type sectionList []string
+
+func (list *sectionList) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		var name string
+		if err := json.Unmarshal(data, &name); err != nil {
+			return errors.New("sections must be a string or an array of strings")
+		}
+		names = []string{name}
+	}
+
+	*list = sectionList{}
+	for _, name := range names {
+		if name != "" {
+			*list = append(*list, name)
+		}
+	}
+	return nil
+}
+
+// Fragments of one collection, rendered once and shared by every client
+type frame struct {
+	time      time.Time
+	sections  []string                           //Every section, in display order
+	fragments map[string][]byte                  //Section -> out of band fragment of the whole section
+	data      map[string][]byte                  //Section -> JSON snapshot (the object of the rows by key for a table)
+	rows      map[string][]hardware.Row          //Table section -> rows, in display order
+	index     map[string]map[string]hardware.Row //Table section -> rows by key
+
+	processes hardware.Processes                   //Processes of the collection, for the process queries
+	viewsLock sync.Mutex                           //Guard views
+	views     map[hardware.ProcessQuery]*tableView //Process query -> its view, rendered when a client first need it
+}
+
+// The processes section rendered for a query
+type tableView struct {
+	fragment []byte
+	data     []byte
+	rows     []hardware.Row
+	index    map[string]hardware.Row
+	total    int
+	summary  string
+}
+
+// Return the view of a validated process query, rendered once per frame for all the clients using it. nil if it failed
+func (frame *frame) processView(query hardware.ProcessQuery) *tableView {
+	frame.viewsLock.Lock()
+	defer frame.viewsLock.Unlock()
+
+	if view, ok := frame.views[query.Key()]; ok {
+		return view
+	}
+
+	var view *tableView
+	table, err := frame.processes.RenderQuery(query)
+	if err != nil {
+		slog.Error("Failed to render process query", "query", query.Key(), "error", err)
+	} else {
+		view = &tableView{
+			fragment: wrapSection(hardware.PROCESS_COLLECTOR, table.Html),
+			data:     rowsObject(table.Rows),
+			rows:     table.Rows,
+			index:    hardware.IndexRows(table.Rows),
+			total:    table.Total,
+			summary:  table.Summary,
+		}
+	}
+	//A failure is not retried for every client
+	frame.views[query.Key()] = view
+	return view
+}
+
+// Out of band fragment replacing the content of a section
+func wrapSection(section string, html template.HTML) []byte {
+	return fmt.Appendf(nil, `<div id="section-%s" hx-swap-oob="innerHTML">%s</div>`, template.HTMLEscapeString(section), html)
+}
+
+// Every section a client can subscribe to
+func (server *Server) sections() []string {
+	var sections []string
+	for _, collector := range server.hw.Collectors() {
+		sections = append(sections, collector.Name())
+	}
+	return append(sections, hardware.STATUS_SECTION, ALERTS_SECTION)
+}
+
+// Render the fragments of every section from the latest collection
+func (server *Server) buildFrame(now time.Time) *frame {
+	frame := &frame{
+		time:      now,
+		sections:  server.sections(),
+		fragments: make(map[string][]byte),
+		data:      make(map[string][]byte),
+		rows:      make(map[string][]hardware.Row),
+		index:     make(map[string]map[string]hardware.Row),
+		views:     make(map[hardware.ProcessQuery]*tableView),
+	}
+	server.hw.Decode(hardware.PROCESS_COLLECTOR, &frame.processes)
+
+	for _, collector := range server.hw.Collectors() {
+		name := collector.Name()
+		section, _ := server.hw.Section(name)
+		frame.fragments[name] = wrapSection(name, section.Html)
+		if section.Rows != nil {
+			frame.rows[name] = section.Rows
+			frame.index[name] = hardware.IndexRows(section.Rows)
+			frame.data[name] = rowsObject(section.Rows)
+		} else {
+			frame.data[name] = server.hw.Snapshot(name)
+		}
+	}
+
+	status, err := server.hw.StatusHtml()
+	if err != nil {
+		slog.Error("Failed to render collectors status", "error", err)
+	} else {
+		frame.fragments[hardware.STATUS_SECTION] = wrapSection(hardware.STATUS_SECTION, status)
+	}
+	if frame.data[hardware.STATUS_SECTION], err = json.Marshal(server.hw.Status()); err != nil {
+		slog.Error("Failed to encode collectors status", "error", err)
+	}
+
+	//The alerts template is already an out of band fragment
+	alerts, err := server.alerts.ToHtml()
+	if err != nil {
+		slog.Error("Failed to render alerts", "error", err)
+	} else {
+		frame.fragments[ALERTS_SECTION] = []byte(alerts)
+	}
+	if frame.data[ALERTS_SECTION], err = json.Marshal(server.alerts.Alerts()); err != nil {
+		slog.Error("Failed to encode alerts", "error", err)
+	}
+
+	return frame
+}
+
+// Render the page layout with the sections of the client
+func (server *Server) layoutMessage(client *Client) ([]byte, error) {
+	html, err := server.hw.ToHtml(client.Wants)
+	if err != nil {
+		return nil, err
+	}
+
+	//The alerts live outside of the layout, clear them if the client does not want them anymore
+	message := []byte(html)
+	if !client.Wants(ALERTS_SECTION) {
+		message = append(message, `<div id="alerts" hx-swap-oob="innerHTML"></div>`...)
+	} else if frame := server.latestFrame(); frame != nil {
+		message = append(message, frame.fragments[ALERTS_SECTION]...)
+	}
+	return message, nil
+}
+
+// Send the page layout to a client, the tables are then sent whole on the next update
+func (server *Server) sendLayout(client *Client) error {
+	layout, err := server.layoutMessage(client)
+	if err != nil {
+		return err
+	}
+
+	server.Lock()
+	defer server.Unlock()
+	if server.clients[client] {
+		client.resetTables()
+		server.enqueue(client, layout)
+
+		//The layout has the default view of the processes, don't wait for the next update to show the client's one
+		if server.frame != nil && !client.Query().IsZero() && client.Wants(hardware.PROCESS_COLLECTOR) {
+			server.enqueue(client, client.update(server.frame, []string{hardware.PROCESS_COLLECTOR}, true))
+		}
+	}
+	return nil
+}
+
+// Send the latest collection of the given sections (the subscribed ones if nil) now, tables included
+func (server *Server) sendLatest(client *Client, sections []string) {
+	server.Lock()
+	defer server.Unlock()
+
+	//Nothing collected yet, the first collection will be sent anyway
+	if server.frame != nil && server.clients[client] {
+		server.enqueue(client, client.update(server.frame, sections, true))
+	}
+}
+
+func (server *Server) latestFrame() *frame {
+	server.Lock()
+	defer server.Unlock()
+	return server.frame
+}
+
+// Handle a message received from a client
+func (server *Server) handleMessage(client *Client, data []byte) error {
+	var message ClientMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return fmt.Errorf("invalid message: %w", err)
+	}
+
+	//Reject the unknown sections, so a typo does not silently show nothing
+	available := server.sections()
+	for _, section := range message.Sections {
+		if !slices.Contains(available, section) {
+			return fmt.Errorf("unknown section %q", section)
+		}
+	}
+
+	switch message.Type {
+	case SUBSCRIBE_MESSAGE:
+		var interval time.Duration
+		if message.Interval != "" {
+			var err error
+			interval, err = time.ParseDuration(message.Interval)
+			if err != nil || interval < 0 {
+				return fmt.Errorf("invalid interval %q", message.Interval)
+			}
+		}
+		if message.Format != "" && message.Format != FORMAT_HTML && message.Format != FORMAT_JSON {
+			return fmt.Errorf("invalid format %q (html or json)", message.Format)
+		}
+		client.Subscribe(message.Sections, max(interval, server.config.Interval), message.Format)
+		slog.Debug("Client subscribed", "client", client.Addr(), "sections", message.Sections, "interval", interval, "format", message.Format)
+
+		//In JSON the client start with a snapshot of its sections, in HTML a new layout is only needed when the sections change
+		if client.Format() == FORMAT_JSON {
+			if message.Sections != nil || message.Format != "" {
+				server.sendLatest(client, nil)
+			}
+		} else if message.Sections != nil || message.Format != "" {
+			return server.sendLayout(client)
+		}
+	case REFRESH_MESSAGE:
+		server.sendLatest(client, message.Sections)
+	case PROCESSES_MESSAGE:
+		query := hardware.ProcessQuery{
+			Sort:   message.Sort,
+			Order:  message.Order,
+			Name:   message.Name,
+			User:   message.User,
+			Regex:  message.Regex,
+			Offset: int(message.Offset),
+			Limit:  int(message.Limit),
+		}
+		if err := query.Validate(); err != nil {
+			return err
+		}
+		client.SetQuery(query)
+		slog.Debug("Client changed its process query", "client", client.Addr(), "query", query.Key())
+		if client.Wants(hardware.PROCESS_COLLECTOR) {
+			server.sendLatest(client, []string{hardware.PROCESS_COLLECTOR})
+		}
+	default:
+		return fmt.Errorf("unknown message type %q", message.Type)
+	}
+	return nil
+}
@@ -1,13 +1,28 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"sys/alert"
+	"sys/audit"
+	"sys/auth"
+	"sys/config"
 	"sys/hardware"
+	"sys/history"
+	"sys/notify"
+	"sys/overlay"
+	"sys/templates"
+	"sys/web"
 	"syscall"
 	"time"
 
@@ -15,51 +30,131 @@ import (
 	"github.com/shirou/gopsutil/process"
 )
 
+const TEMPLATE_WATCH_INTERVAL = time.Second //How often the templates are checked for changes in development mode
+
 /*---Variable and type declaration---*/
-var (
-	// Web socket upgrader
-	wsUpgrader = websocket.Upgrader{
+type Server struct {
+	sync.Mutex                     //Embedding mutex to avoid race condition
+	config     *config.Config      //Server configuration
+	mux        http.ServeMux       //The server multiplxer
+	upgrader   websocket.Upgrader  //Web socket upgrader, only accept the server's own origin and the allowed ones
+	auth       *auth.Authenticator //Users authentication, nil if it is disabled
+	audit      *audit.Log          //Trail of the actions on processes
+	clients    map[*Client]bool    //Map used to keep track of all clients currently connecting to the server
+	slowPolicy SlowClientPolicy    //What to do with a client whose queue is full
+	hw         *hardware.Hardware  //The latest hardware snapshot, shared by the websocket loop and the API
+	history    *history.Store      //Recent values of every sample, fed by the collection loop
+	storage    *history.DiskStore  //Persistent history, nil if it could not be opened
+	alerts     *alert.Engine       //Alert rules evaluated after every collection
+	notifier   *notify.Dispatcher  //Deliver the alert events to webhooks, email and syslog
+	httpServer *http.Server        //The HTTP server, created in Start
+	writers    sync.WaitGroup      //Running client writer goroutines
+	closing    bool                //Set when the shutdown started, new clients are refused
+	done       chan struct{}       //Done channel, closed to stop the collection loop
+	loopDone   chan struct{}       //Closed when the collection loop has returned
+	frame      *frame              //Fragments of the latest collection, for the refresh requests
+	web        fs.FS               //The web page and its static resources, set in Start
+}
+
+func NewServer(cfg *config.Config) *Server {
+	//Create the enabled collectors with their own interval and timeout (settings of disabled collectors are ignored)
+	hw := hardware.NewHardware(cfg.Collectors.Enabled...)
+	for name, interval := range cfg.Collectors.Intervals {
+		hw.SetInterval(name, interval)
+	}
+	for name, timeout := range cfg.Collectors.Timeouts {
+		hw.SetTimeout(name, timeout)
+	}
+	if hw.ProcessInfo != nil {
+		hw.ProcessInfo.NormalizeCPU = cfg.Collectors.NormalizeProcessCPU
+	}
+
+	slowPolicy := DropOldest
+	if cfg.Limits.SlowClientPolicy == "disconnect" {
+		slowPolicy = Disconnect
+	}
+
+	//A dispatcher without route, replaced in Start if the notification file is valid
+	notifier, _ := notify.NewDispatcher(&notify.Config{})
+
+	server := &Server{
+		config:     cfg,
+		mux:        *http.NewServeMux(),
+		clients:    make(map[*Client]bool),
+		slowPolicy: slowPolicy,
+		hw:         hw,
+		history:    history.NewStore(cfg.History.Retention, cfg.Interval),
+		alerts:     alert.NewEngine(nil),
+		notifier:   notifier,
+		done:       make(chan struct{}),
+		loopDone:   make(chan struct{}),
+	}
+	server.upgrader = websocket.Upgrader{
 		WriteBufferSize: 1024,
 		ReadBufferSize:  1024,
-		//For simplicity, we will want to return the Check Origin true for all clients connect to the server
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		CheckOrigin:     server.checkOrigin,
 	}
+	return server
+}
 
-	//Broadcast channel for the server to send message to
-	Broadcast = make(chan []byte, 1024)
-)
+/*---Broadcast hub---*/
 
-type Server struct {
-	sync.Mutex                  //Embedding mutex to avoid race condition
-	mux        http.ServeMux    //The server multiplxer
-	clients    map[*Client]bool //Map used to keep track of all clients currently connecting to the server
-	done       chan struct{}    //Done channel, used for graceful shutdown (not implemented yet)
+// Send the subscribed sections of a new collection to every client whose refresh interval is elapsed
+func (server *Server) Publish(frame *frame) {
+	//Lock the server struct so no client can be removed (and its queue closed) while we are sending
+	server.Lock()
+	defer server.Unlock()
+
+	server.frame = frame
+	for client := range server.clients {
+		if client.due(frame.time, server.config.Interval) {
+			server.enqueue(client, client.update(frame, nil, false))
+		}
+	}
 }
 
-func NewServer() *Server {
-	return &Server{
-		mux:     *http.NewServeMux(),
-		clients: make(map[*Client]bool),
-		done:    make(chan struct{}),
+// Queue a message for a client, the server must be locked
+func (server *Server) enqueue(client *Client, msg []byte) {
+	if len(msg) == 0 {
+		return
+	}
+	if !client.Enqueue(msg, server.slowPolicy) {
+		//The client cannot keep up, close the connection so its writer goroutine fail and clean it up
+		slog.Warn("Client is too slow, closing connection", "client", client.Addr())
+		client.conn.Close()
 	}
 }
 
 /*---Handle websocket---*/
 
 func (server *Server) Serve_WebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	conn, err := server.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		fmt.Printf("Failed to upgrade web socket request\nError: %v\n", err)
+		slog.Warn("Failed to upgrade web socket request", "remote_addr", r.RemoteAddr, "error", err)
 		return
 	}
 
 	//If a connection upgrade success, add new publisher
 	client := server.AddClient(conn)
+	if client == nil {
+		//The server is shutting down
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+		conn.Close()
+		return
+	}
+
+	//Start with the whole page, the client can then subscribe to some sections only.
+	//API clients can ask for JSON right away, and start with a snapshot instead
+	if r.URL.Query().Get("format") == FORMAT_JSON {
+		client.Subscribe(nil, server.config.Interval, FORMAT_JSON)
+		server.sendLatest(client, nil)
+	} else if err := server.sendLayout(client); err != nil {
+		slog.Error("Failed to render page layout", "client", client.Addr(), "error", err)
+	}
 
-	//Start independent goroutine
+	//Start independent goroutines, one writing the queued messages and one reading the client's requests
 	go client.SendMessages()
+	go client.ReadMessages()
 }
 
 func (server *Server) AddClient(conn *websocket.Conn) *Client {
@@ -67,11 +162,17 @@ func (server *Server) AddClient(conn *websocket.Conn) *Client {
 	server.Lock()
 	defer server.Unlock()
 
+	//Don't accept new clients once the shutdown started
+	if server.closing {
+		return nil
+	}
+
 	//Create new client and registered it to the server map
 	client := NewClient(conn, server)
 	server.clients[client] = true
+	server.writers.Add(1)
 
-	fmt.Println("New client join the server")
+	slog.Info("Client connected", "client", client.Addr(), "clients", len(server.clients))
 
 	return client
 }
@@ -83,25 +184,23 @@ func (server *Server) RemoveClient(client *Client) {
 
 	//Check if the current client has been registered
 	if _, hasRegistered := server.clients[client]; hasRegistered {
-		//Close the channel message
+		//Close the channel message, the writer goroutine then close the connection
 		close(client.msgs)
-		//Close the connection
-		client.conn.Close()
 		//Remove publisher from the list of publisher
 		delete(server.clients, client)
 
-		fmt.Println("Client lost connection")
+		slog.Info("Client disconnected", "client", client.Addr(), "clients", len(server.clients))
 
 		return
 	}
 
-	//If this client has not been registered, then print out the message
-	fmt.Println("Cannot found this client!")
+	//Already removed, ex: by the shutdown
+	slog.Debug("Client is not registered", "client", client.Addr())
 }
 
 func (server *Server) HandleProcessAction(w http.ResponseWriter, r *http.Request) {
 	/*
-	 * Handler for handling the process action: kill, terminate or send signal
+	 * Handler for handling the process action: kill, terminate, send signal or kill the process and all its descendants
 	 * We don't need a web socket connection here, since we fetch the data and send to all clients in a fixed interval
 	 */
 
@@ -114,25 +213,53 @@ func (server *Server) HandleProcessAction(w http.ResponseWriter, r *http.Request
 	action = strings.ToLower(action)
 	pid, err := strconv.Atoi(pidRaw)
 	if err != nil {
-		fmt.Printf("Failed to parse PID\nError: %v\n", err)
+		slog.Warn("Invalid PID in process action", "pid", pidRaw, "error", err)
 		http.Error(w, "Invalid PID", http.StatusBadRequest)
 		return
 	}
 
+	//Every action on a PID is recorded in the audit log, whatever its outcome
+	user := auth.UserFromContext(r.Context())
+	entry := audit.Entry{
+		Time:       time.Now(),
+		User:       user.Name,
+		Role:       string(user.Role),
+		AuthMethod: auth.MethodFromContext(r.Context()),
+		RemoteAddr: remoteIP(r),
+		Action:     action,
+		PID:        int32(pid),
+		Outcome:    audit.SUCCESS,
+	}
+	defer func() {
+		slog.Info("Process action", "user", entry.User, "action", entry.Action, "pid", entry.PID,
+			"process", entry.ProcessName, "outcome", entry.Outcome, "error", entry.Error)
+		if err := server.audit.Record(entry); err != nil {
+			slog.Error("Failed to write the audit log", "action", entry.Action, "pid", entry.PID, "error", err)
+		}
+	}()
+	fail := func(status int, message string, err error) {
+		entry.Outcome = audit.FAILURE
+		entry.Error = err.Error()
+		http.Error(w, message, status)
+	}
+
 	//Get the process by PID
 	proc, err := process.NewProcess(int32(pid))
 	if err != nil {
-		fmt.Printf("Failed to get process with PID %d to perform action\nError: %v\n", pid, err)
-		http.Error(w, "Fail to execute action to process", http.StatusInternalServerError)
+		fail(http.StatusInternalServerError, "Fail to execute action to process", err)
 		return
 	}
 
+	//Remember what the process was, it may be gone after the action
+	entry.ProcessName, _ = proc.Name()
+	entry.Cmdline, _ = proc.Cmdline()
+
 	//Check for each action
 	switch action {
 	case "kill":
 		err := proc.Kill()
 		if err != nil {
-			http.Error(w, "Internal server error: Failed to kill process", http.StatusInternalServerError)
+			fail(http.StatusInternalServerError, "Internal server error: Failed to kill process", err)
 		} else {
 			//Send success response message
 			w.WriteHeader(http.StatusOK)
@@ -141,82 +268,313 @@ func (server *Server) HandleProcessAction(w http.ResponseWriter, r *http.Request
 	case "terminate":
 		err := proc.Terminate()
 		if err != nil {
-			http.Error(w, "Internal server error: Failed to terminate process", http.StatusInternalServerError)
+			fail(http.StatusInternalServerError, "Internal server error: Failed to terminate process", err)
 		} else {
 			//Send success response message
 			w.WriteHeader(http.StatusOK)
 			w.Write(fmt.Appendf(nil, "Process with PID %d terminate sucessfully", pid))
 		}
+	case "kill_tree":
+		//Freeze the process first, so it cannot fork new children while we look for them
+		if err := proc.Suspend(); err != nil {
+			fail(http.StatusInternalServerError, "Internal server error: Failed to kill process tree", err)
+			return
+		}
+		descendants, err := hardware.Descendants(int32(pid))
+		if err != nil {
+			proc.Resume()
+			fail(http.StatusInternalServerError, "Internal server error: Failed to kill process tree", err)
+			return
+		}
+
+		//Freeze the descendants too (parents before children), then kill everything.
+		//A descendant may exit on its own meanwhile, so only the process itself can fail the action
+		for _, child := range descendants {
+			syscall.Kill(int(child), syscall.SIGSTOP)
+		}
+		for _, child := range descendants {
+			syscall.Kill(int(child), syscall.SIGKILL)
+		}
+		entry.Descendants = descendants
+		err = proc.Kill()
+		if err != nil {
+			fail(http.StatusInternalServerError, "Internal server error: Failed to kill process tree", err)
+		} else {
+			//Send success response message
+			w.WriteHeader(http.StatusOK)
+			w.Write(fmt.Appendf(nil, "Process with PID %d and its %d descendants kill sucessfully", pid, len(descendants)))
+		}
 	case "send_signal":
 		//Get the signal value
 		signalRaw := params.Get("signal")
 		signal, err := strconv.Atoi(signalRaw)
 		if err != nil {
-			fmt.Printf("Failed to parse Signal\nError: %v\n", err)
-			http.Error(w, "Invalid Signal", http.StatusBadRequest)
+			fail(http.StatusBadRequest, "Invalid Signal", err)
 			return
 		}
+		entry.Signal = signal
 		err = proc.SendSignal(syscall.Signal(signal))
 		if err != nil {
-			http.Error(w, "Internal server error: Failed to send signal to process", http.StatusInternalServerError)
+			fail(http.StatusInternalServerError, "Internal server error: Failed to send signal to process", err)
 		} else {
 			//Send success response message
 			w.WriteHeader(http.StatusOK)
 			w.Write(fmt.Appendf(nil, "Process with PID %d receive signal sucessfully", pid))
 		}
+	default:
+		fail(http.StatusBadRequest, "Invalid action", fmt.Errorf("unknown action %q", action))
+	}
+}
+
+// IP address of the client, the port is not meaningful for the audit
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+	return host
+}
+
+/*
+ * First start without users file: create it with an operator whose credentials are printed once on the standard
+ * error (never in the log file), so upgrading to a version with the authentication does not stop the server
+ */
+func (server *Server) createFirstUser() (*auth.Users, error) {
+	path := server.config.Paths.Users
+	password, token, err := auth.CreateFirstUser(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the first user: %w", err)
+	}
+
+	slog.Warn("No users file, created one with a generated operator, its credentials are printed once on the standard error", "file", path, "user", auth.FIRST_USER)
+	fmt.Fprintf(os.Stderr, "\nNo users file: created %s with the operator %q.\n", path, auth.FIRST_USER)
+	fmt.Fprintf(os.Stderr, "  Password (for the dashboard): %s\n", password)
+	fmt.Fprintf(os.Stderr, "  API token (Authorization: Bearer <token>): %s\n", token)
+	fmt.Fprintf(os.Stderr, "They are not shown again, only their hashes are stored. Edit %s to change them.\n\n", path)
+
+	return auth.LoadUsers(path)
 }
 
 /*---Config server---*/
-func (server *Server) Start() {
+
+/*
+ * Start the server and serve until it fails or a signal asks it to stop. Return an error if it could not start
+ * or failed while serving, the resources it opened are closed before returning
+ */
+func (server *Server) Start() error {
+	//Load the users, the server refuse to start without them unless the authentication is disabled
+	if server.config.Auth.Enabled {
+		users, err := auth.LoadUsers(server.config.Paths.Users)
+		if errors.Is(err, fs.ErrNotExist) {
+			users, err = server.createFirstUser()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load the users from %s (create them with `sys hash-password` or disable the authentication): %w", server.config.Paths.Users, err)
+		}
+		server.auth = auth.NewAuthenticator(users, server.config.Auth.SessionTTL)
+	} else {
+		slog.Warn("Authentication is disabled, anyone reaching the server can act on the processes")
+	}
+
+	//Parse the templates once, they are then shared by every render. The files of the templates directory
+	//(if any) replace the embedded ones
+	templateFiles, err := overlay.New(server.config.Paths.Templates, templates.Files)
+	if err == nil {
+		err = hardware.LoadTemplates(templateFiles)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load the templates from %s: %w", server.config.Paths.Templates, err)
+	}
+	if server.config.Dev {
+		slog.Info("Development mode, watching the templates", "dir", server.config.Paths.Templates)
+		go hardware.WatchTemplates(TEMPLATE_WATCH_INTERVAL, server.done)
+	}
+
 	/*---Serve the static files---*/
 
+	//The page is embedded, the files of the static directory (if any) replace the embedded ones
+	webFiles, err := overlay.New(server.config.Paths.Static, web.Files)
+	if err != nil {
+		return fmt.Errorf("failed to open the static directory %s: %w", server.config.Paths.Static, err)
+	}
+	server.web = webFiles
+
+	//Load the TLS certificate and listen before opening anything, so these failures do not leave files to close
+	var reloader *certReloader
+	if server.config.TLS.Enabled() {
+		reloader, err = newCertReloader(server.config.TLS)
+		if err != nil {
+			return fmt.Errorf("failed to load the TLS certificate %s (key %s): %w", server.config.TLS.Cert, server.config.TLS.Key, err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", server.config.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", server.config.Listen, err)
+	}
+
+	//Every process action must leave a trail, the server refuse to start without the audit log.
+	//Nothing can fail after it is opened until the server is running, then Shutdown close it
+	auditLog, err := audit.Open(server.config.Paths.Audit)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to open the audit log %s: %w", server.config.Paths.Audit, err)
+	}
+	server.audit = auditLog
+
 	//Serve the index.html file
-	fs := http.FileServer(http.Dir("./web"))
-	server.mux.Handle("/", fs)
+	server.handle("/", auth.Viewer, http.FileServerFS(webFiles))
 
-	//Serve the static resources
-	fs = http.FileServer(http.Dir("./web/static"))
-	server.mux.Handle("/static/", http.StripPrefix("/static", fs))
+	//Serve the static resources (public, the login page need them)
+	staticFiles, _ := fs.Sub(webFiles, "static")
+	server.mux.Handle("/static/", http.StripPrefix("/static", http.FileServerFS(staticFiles)))
+
+	//Login page and sessions
+	server.mux.HandleFunc("GET "+auth.LOGIN_PATH, server.HandleLoginPage)
+	server.mux.HandleFunc("POST "+auth.LOGIN_PATH, server.auth.HandleLogin)
+	server.mux.HandleFunc("POST "+auth.LOGOUT_PATH, server.auth.HandleLogout)
 
 	//Handler for upgrading from HTTP to Web Socket
-	server.mux.HandleFunc("/ws", server.Serve_WebSocket)
-	server.mux.HandleFunc("/process", server.HandleProcessAction)
+	server.handle("/ws", auth.Viewer, http.HandlerFunc(server.Serve_WebSocket))
+
+	//Acting on processes is reserved to operators
+	server.handle("POST /process", auth.Operator, http.HandlerFunc(server.HandleProcessAction))
+
+	//JSON API
+	server.RegisterAPI()
+
+	//Prometheus exporter
+	server.handle("GET /metrics", auth.Viewer, http.HandlerFunc(server.HandleMetrics))
+
+	//Open the persistent history, the server still work (with in-memory history only) if it fails
+	if server.config.History.Persist {
+		storage, err := history.OpenDiskStore(server.config.Paths.Data, history.DefaultLevels)
+		if err != nil {
+			slog.Error("Failed to open the history storage, history will not be persisted", "dir", server.config.Paths.Data, "error", err)
+		} else {
+			server.storage = storage
+		}
+	}
+
+	//Load the alert rules, the server still work (without alerting) if the file is invalid
+	rules, err := alert.LoadRules(server.config.Paths.AlertRules)
+	if err == nil {
+		var collectors []string
+		for _, collector := range server.hw.Collectors() {
+			collectors = append(collectors, collector.Name())
+		}
+		err = alert.CheckCollectors(rules, collectors)
+	}
+	if err != nil {
+		slog.Error("Failed to load the alert rules, alerting is disabled", "file", server.config.Paths.AlertRules, "error", err)
+	} else {
+		server.alerts = alert.NewEngine(rules)
+	}
+
+	//Load the notification routes, the alerts are still shown on the dashboard if it fails
+	notifyConfig, err := notify.LoadConfig(server.config.Paths.Notify)
+	if err == nil {
+		var notifier *notify.Dispatcher
+		notifier, err = notify.NewDispatcher(notifyConfig)
+		if err == nil {
+			server.notifier = notifier
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to load the notification configuration, notifications are disabled", "file", server.config.Paths.Notify, "error", err)
+	}
 
 	//Start the goroutine for collecting system data
 	go func() {
+		defer close(server.loopDone)
+
 		//We used ticker (which has a channel as a field) for fetching data internally instead of using time.Sleep
-		ticker := time.NewTicker(time.Second) //Set interval is 1 second
+		ticker := time.NewTicker(server.config.Interval)
 		defer ticker.Stop()
 
-		//Create the hardware struct
-		hw := hardware.NewHardware()
-
 		//Fetch data continously until we receive some data in done channel (most of the time is server manually shutdown)
 		for {
 			select {
-			case <-ticker.C:
-				hw.CollectData()
-				html, err := hw.ToHtml(hardware.TMPL)
-				if err == nil {
-					//If we success to get the data, then send it to broadcast
-					Broadcast <- []byte(html)
-				} else {
-					//If fail, let's just log the error and ignore the current fetching
-					fmt.Printf("Failed to collect system data\nError: %v\n", err)
+			case now := <-ticker.C:
+				//A failing collector does not stop the others, we still render what we have (failures are logged by each collector)
+				server.hw.CollectData()
+
+				//Keep track of the values over time
+				samples := server.hw.Samples(server.config.Limits.MaxProcessSeries)
+				server.history.Record(now, samples)
+				if server.storage != nil {
+					err := server.storage.Append(now, samples)
+					if err != nil {
+						slog.Error("Failed to persist samples", "error", err)
+					}
 				}
-			case <-server.done: //Not implemented yet
+
+				//Evaluate the alert rules on the new data
+				//The processes are only given when collected this tick, old or missing ones would trigger the process rules
+				var processes hardware.Processes
+				if !server.hw.DecodeFresh(hardware.PROCESS_COLLECTOR, &processes) {
+					processes = nil
+				}
+				events := server.alerts.Evaluate(now, samples, processes)
+				for _, changed := range events {
+					slog.Info("Alert state changed", "rule", changed.Rule, "key", changed.Key, "state", changed.State,
+						"severity", changed.Severity, "description", changed.Description)
+				}
+				server.notifier.Notify(events)
+
+				//Render every section once, then send each client the ones it subscribed to
+				server.Publish(server.buildFrame(now))
+			case <-server.done:
 				return
 			}
 		}
 
 	}()
 
-	//Listen and serve
-	fmt.Println("Start server at http://localhost:8800 ...")
-	err := http.ListenAndServe(":8800", &server.mux)
-	if err != nil {
-		fmt.Printf("Failed to start server\nError: %v\n", err)
-		os.Exit(1)
+	//Listen and serve until the server fail or we are asked to stop
+	server.httpServer = &http.Server{
+		Addr:     server.config.Listen,
+		Handler:  &server.mux,
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn), //ex: TLS handshake errors
+	}
+	serveErr := make(chan error, 1)
+	if reloader != nil {
+		go reloader.watch(server.done)
+		server.httpServer.TLSConfig = reloader.tlsConfig()
+		//A non nil empty map disable HTTP/2, which net/http would otherwise add to the offered protocols
+		server.httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+
+		go func() {
+			//The certificate come from the TLS config
+			serveErr <- server.httpServer.ServeTLS(listener, "", "")
+		}()
+		slog.Info("Server started", "listen", server.config.Listen, "tls", true, "mutual_tls", server.config.TLS.ClientCA != "")
+	} else {
+		go func() {
+			serveErr <- server.httpServer.Serve(listener)
+		}()
+		slog.Info("Server started", "listen", server.config.Listen, "tls", false)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err = <-serveErr:
+		//Stop the collection and flush what was collected before reporting the failure
+		server.Shutdown()
+		return fmt.Errorf("failed to serve on %s: %w", server.config.Listen, err)
+	case sig := <-signals:
+		slog.Info("Shutting down", "signal", sig.String())
 	}
+
+	//A second signal skip the graceful shutdown
+	go func() {
+		<-signals
+		slog.Warn("Received second signal, exiting now")
+		os.Exit(1)
+	}()
+
+	server.Shutdown()
+	return nil
 }
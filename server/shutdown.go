@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+)
+
+/*
+ * Stop the server gracefully, every step share the configured deadline:
+ * 1. Stop the collection loop (the current collection is allowed to finish)
+ * 2. Stop accepting connections and wait for the in-flight HTTP requests (ex: /process)
+ * 3. Send a close frame to every websocket client and wait for their writer to finish
+ * 4. Send the pending notifications, close the audit log and flush the on-disk history
+ */
+func (server *Server) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), server.config.ShutdownTimeout)
+	defer cancel()
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		//Stop the collection loop
+		close(server.done)
+		select {
+		case <-server.loopDone:
+		case <-ctx.Done():
+			return
+		}
+
+		//Stop the HTTP server, this wait for the running handlers
+		if server.httpServer != nil {
+			if err := server.httpServer.Shutdown(ctx); err != nil {
+				slog.Error("Failed to stop the HTTP server gracefully", "error", err)
+			}
+		}
+
+		//Hijacked websocket connections are not tracked by the HTTP server, close them ourselves
+		server.closeClients()
+		writersDone := make(chan struct{})
+		go func() {
+			server.writers.Wait()
+			close(writersDone)
+		}()
+		select {
+		case <-writersDone:
+		case <-ctx.Done():
+			return
+		}
+
+		//Flush what is still buffered
+		server.notifier.Close()
+		if err := server.audit.Close(); err != nil {
+			slog.Error("Failed to close the audit log", "error", err)
+		}
+		if server.storage != nil {
+			if err := server.storage.Close(); err != nil {
+				slog.Error("Failed to flush the history storage", "error", err)
+			}
+		}
+	}()
+
+	select {
+	case <-finished:
+		if ctx.Err() != nil {
+			slog.Error("Shutdown deadline exceeded, some data may be lost", "timeout", server.config.ShutdownTimeout)
+		} else {
+			slog.Info("Server stopped")
+		}
+	case <-ctx.Done():
+		slog.Error("Shutdown deadline exceeded, some data may be lost", "timeout", server.config.ShutdownTimeout)
+	}
+}
+
+// Refuse new clients and close the queue of every client, so their writer send a close frame and return
+func (server *Server) closeClients() {
+	server.Lock()
+	defer server.Unlock()
+
+	server.closing = true
+	for client := range server.clients {
+		close(client.msgs)
+		delete(server.clients, client)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"sys/config"
+	"time"
+)
+
+const TLS_RELOAD_INTERVAL = 10 * time.Second //How often the certificate files are checked for changes
+
+/*
+ * Serve the certificate (and the client CA for mutual TLS) from files that can be replaced while the server run,
+ * ex: renewed by certbot. The files are polled, a reload that fails keep the previous certificate
+ */
+type certReloader struct {
+	sync.RWMutex
+	config   config.TLSConfig
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time //File -> modification time of the loaded version
+}
+
+func newCertReloader(cfg config.TLSConfig) (*certReloader, error) {
+	reloader := &certReloader{config: cfg}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *certReloader) files() []string {
+	files := []string{reloader.config.Cert, reloader.config.Key}
+	if reloader.config.ClientCA != "" {
+		files = append(files, reloader.config.ClientCA)
+	}
+	return files
+}
+
+func (reloader *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range reloader.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.config.Cert, reloader.config.Key)
+	if err != nil {
+		return err
+	}
+
+	var clientCA *x509.CertPool
+	if reloader.config.ClientCA != "" {
+		data, err := os.ReadFile(reloader.config.ClientCA)
+		if err != nil {
+			return err
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(data) {
+			return errors.New("no certificate found in " + reloader.config.ClientCA)
+		}
+	}
+
+	reloader.Lock()
+	defer reloader.Unlock()
+	reloader.cert = &cert
+	reloader.clientCA = clientCA
+	reloader.modTimes = modTimes
+	return nil
+}
+
+// Whether one of the files has been modified since the last load
+func (reloader *certReloader) changed() bool {
+	reloader.RLock()
+	defer reloader.RUnlock()
+
+	for _, file := range reloader.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			//Probably being replaced, try again at the next check
+			continue
+		}
+		if !info.ModTime().Equal(reloader.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the files until done is closed
+func (reloader *certReloader) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(TLS_RELOAD_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !reloader.changed() {
+				continue
+			}
+			if err := reloader.load(); err != nil {
+				slog.Error("Failed to reload the TLS certificate, keeping the previous one", "cert", reloader.config.Cert, "error", err)
+			} else {
+				slog.Info("TLS certificate reloaded", "cert", reloader.config.Cert)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// TLS configuration of the server, the certificate and client CA are looked up on every handshake
+func (reloader *certReloader) tlsConfig() *tls.Config {
+	clientAuth := tls.NoClientCert
+	if reloader.config.ClientCA != "" {
+		clientAuth = tls.RequireAndVerifyClientCert
+		if reloader.config.ClientAuth == "optional" {
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	//The websocket upgrade need HTTP/1.1, so HTTP/2 is not offered (the server also clear TLSNextProto, see Start)
+	base := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"http/1.1"}}
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		reloader.RLock()
+		defer reloader.RUnlock()
+		return reloader.cert, nil
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		reloader.RLock()
+		defer reloader.RUnlock()
+
+		perConn := base.Clone()
+		perConn.GetConfigForClient = nil
+		perConn.ClientAuth = clientAuth
+		perConn.ClientCAs = reloader.clientCA
+		return perConn, nil
+	}
+	return base
+}
@@ -0,0 +1,8 @@
+package templates
+
+import "embed"
+
+// The HTML templates of the dashboard, built into the binary
+//
+//go:embed *.html
+var Files embed.FS
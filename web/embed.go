@@ -0,0 +1,8 @@
+package web
+
+import "embed"
+
+// The web page and its static resources, built into the binary
+//
+//go:embed index.html login.html static
+var Files embed.FS